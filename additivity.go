@@ -0,0 +1,75 @@
+package xgbshap
+
+import (
+	"fmt"
+	"math"
+)
+
+// AdditivityError is returned by contribution calculations using the
+// VerifyAdditivity option when the contributions do not sum to the margin.
+type AdditivityError struct {
+	// Group is the output group whose contributions are wrong.
+	Group int
+	// Sum is the sum of the group's contributions, including the bias.
+	Sum float32
+	// Margin is the group's margin, as PredictMargin returns it.
+	Margin float32
+}
+
+func (e *AdditivityError) Error() string {
+	return fmt.Sprintf(
+		"contributions of output group %d sum to %v but the margin is %v",
+		e.Group,
+		e.Sum,
+		e.Margin,
+	)
+}
+
+// checkAdditivity checks that the contributions of each output group in
+// contribs sum to the group's margin for features, as set up by the
+// VerifyAdditivity option. It does not allocate unless the check fails.
+func (p *Predictor) checkAdditivity(
+	contribs []float32,
+	features []*float32,
+	o *PredictOptions,
+) error {
+	nColumns := len(features) + 1
+	treeBegin, treeEnd := p.treeRange(o)
+
+	for gid := range p.numGroup {
+		// This sums the trees' outputs as predictMargin does, so that the
+		// margin is exactly what PredictMargin returns.
+		margin := p.baseMargin[gid]
+		if o.baseMargin != nil {
+			margin = o.baseMargin[gid]
+		}
+		for i := treeBegin; i < treeEnd; i++ {
+			if p.treeGroups[i] == gid {
+				margin += predictValue(p.trees[i], features) * p.treeWeight(i)
+			}
+		}
+
+		var sum float64
+		for _, c := range contribs[gid*nColumns : (gid+1)*nColumns] {
+			sum += float64(c)
+		}
+
+		// A NaN sum or margin fails this comparison and so is reported.
+		diff := math.Abs(sum - float64(margin))
+		if diff <= float64(p.additivityTolerance) {
+			continue
+		}
+
+		err := &AdditivityError{
+			Group:  gid,
+			Sum:    float32(sum),
+			Margin: margin,
+		}
+		if p.additivityHook == nil {
+			return err
+		}
+		p.additivityHook(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package xgbshap
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyAdditivity(t *testing.T) {
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
+	p, err := NewPredictor(
+		"testdata/roundtrip/model.json",
+		VerifyAdditivity(1e-4),
+	)
+	require.NoError(t, err)
+
+	t.Run("correct contributions pass", func(t *testing.T) {
+		for row, features := range allFeatures {
+			_, err := p.PredictContributions(features)
+			require.NoError(t, err, "row %d", row)
+
+			_, err = p.PredictApproxContributions(features)
+			require.NoError(t, err, "row %d", row)
+
+			_, err = p.PredictContributions(
+				features,
+				BaseMargin(3),
+				Iterations(1, 4),
+			)
+			require.NoError(t, err, "row %d", row)
+		}
+
+		_, err := p.PredictInteractions(allFeatures[0])
+		require.NoError(t, err)
+
+		_, err = p.PredictContributionsBatch(t.Context(), allFeatures)
+		require.NoError(t, err)
+
+		e, err := NewInterventionalExplainer(p, allFeatures[:10])
+		require.NoError(t, err)
+		_, err = e.PredictContributions(allFeatures[20])
+		require.NoError(t, err)
+	})
+
+	t.Run("multiple groups", func(t *testing.T) {
+		p, err := NewPredictor(
+			"testdata/multiclass/model.json",
+			VerifyAdditivity(1e-4),
+		)
+		require.NoError(t, err)
+
+		for _, x := range []float32{-1, 0, 0.5, 2} {
+			_, err := p.PredictContributionsMulticlass(
+				[]*float32{toPtr(x), toPtr(-x)},
+			)
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("conditional contributions are not checked", func(t *testing.T) {
+		_, err := p.PredictConditionalContributions(allFeatures[0], 0, 1)
+		require.NoError(t, err)
+	})
+}
+
+func TestVerifyAdditivityDetectsErrors(t *testing.T) {
+	// Shifting the root's mean value shifts the bias without changing the
+	// margin, as a base_score in the wrong space would.
+	newBrokenPredictor := func(t *testing.T, opts ...Option) *Predictor {
+		p, err := NewPredictor("testdata/interaction/model.json", opts...)
+		require.NoError(t, err)
+		p.trees[0].meanValues[0] += 0.5
+		return p
+	}
+
+	features := []*float32{toPtr(1), toPtr(1)}
+
+	t.Run("error", func(t *testing.T) {
+		p := newBrokenPredictor(t, VerifyAdditivity(1e-4))
+
+		_, err := p.PredictContributions(features)
+		var additivityErr *AdditivityError
+		require.ErrorAs(t, err, &additivityErr)
+		assert.Equal(
+			t,
+			&AdditivityError{Group: 0, Sum: 4.5, Margin: 4},
+			additivityErr,
+		)
+		require.EqualError(
+			t,
+			err,
+			"contributions of output group 0 sum to 4.5 but the margin is 4",
+		)
+
+		err = p.PredictContributionsInto(make([]float32, 3), features, nil)
+		require.ErrorAs(t, err, &additivityErr)
+
+		_, err = p.PredictContributionsBatch(
+			t.Context(),
+			[][]*float32{features},
+		)
+		require.ErrorAs(t, err, &additivityErr)
+		require.ErrorContains(t, err, "row 0: ")
+	})
+
+	t.Run("within tolerance", func(t *testing.T) {
+		p := newBrokenPredictor(t, VerifyAdditivity(0.5))
+
+		_, err := p.PredictContributions(features)
+		require.NoError(t, err)
+	})
+
+	t.Run("not verified by default", func(t *testing.T) {
+		p := newBrokenPredictor(t)
+
+		_, err := p.PredictContributions(features)
+		require.NoError(t, err)
+	})
+
+	t.Run("hook", func(t *testing.T) {
+		var (
+			mu       sync.Mutex
+			failures []*AdditivityError
+		)
+		p := newBrokenPredictor(
+			t,
+			VerifyAdditivity(1e-4),
+			OnAdditivityError(func(err *AdditivityError) {
+				mu.Lock()
+				defer mu.Unlock()
+				failures = append(failures, err)
+			}),
+		)
+
+		contributions, err := p.PredictContributions(features)
+		require.NoError(t, err)
+		assert.Equal(t, []float32{1.5, 1.5, 1.5}, contributions)
+
+		_, err = p.PredictContributionsBatch(
+			t.Context(),
+			[][]*float32{features, features},
+			Workers(2),
+		)
+		require.NoError(t, err)
+
+		assert.Len(t, failures, 3)
+	})
+}
+
+func TestVerifyAdditivityDoesNotAllocate(t *testing.T) {
+	p, err := NewPredictor(
+		"testdata/small-model/model.json",
+		VerifyAdditivity(1e-4),
+	)
+	require.NoError(t, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/small-model/features.csv")
+	require.NoError(t, err)
+
+	var scratch Scratch
+	dst := make([]float32, len(allFeatures[0])+1)
+
+	allocs := testing.AllocsPerRun(10, func() {
+		for _, features := range allFeatures {
+			err := p.PredictContributionsInto(dst, features, &scratch)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestVerifyAdditivityInvalidTolerance(t *testing.T) {
+	for _, tolerance := range []float32{-1, float32(math.NaN())} {
+		_, err := NewPredictor(
+			"testdata/interaction/model.json",
+			VerifyAdditivity(tolerance),
+		)
+		require.ErrorContains(t, err, "invalid additivity tolerance")
+	}
+}
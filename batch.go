@@ -0,0 +1,130 @@
+package xgbshap
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// BatchOptions holds options for batch predictions.
+type BatchOptions struct {
+	workers int
+}
+
+// BatchOption is a configuration function for batch predictions.
+type BatchOption func(*BatchOptions)
+
+// Workers sets the number of goroutines that a batch prediction uses. The
+// default is runtime.GOMAXPROCS(0). Values less than 1 use the default.
+func Workers(n int) func(*BatchOptions) {
+	return func(o *BatchOptions) {
+		o.workers = n
+	}
+}
+
+// PredictContributionsBatch calculates the contributions of features for each
+// of rows, splitting the rows across worker goroutines. Each element of the
+// result is what PredictContributions returns for the corresponding row.
+//
+// Each worker reuses its own Scratch, so the only allocations are for the
+// results. If ctx is canceled, the workers stop and ctx's error is returned.
+// If calculating a row fails, the workers stop and that error is returned.
+func (p *Predictor) PredictContributionsBatch(
+	ctx context.Context,
+	rows [][]*float32,
+	opts ...BatchOption,
+) ([][]float32, error) {
+	if err := p.checkSingleGroup("PredictContributionsBatch"); err != nil {
+		return nil, err
+	}
+
+	return p.runBatch(
+		ctx,
+		len(rows),
+		opts,
+		func(i int, po *PredictOptions, scratch *Scratch) ([]float32, error) {
+			contribs := make([]float32, len(rows[i])+1)
+			err := p.predictContributionsInto(
+				contribs,
+				rows[i],
+				po,
+				false,
+				0,
+				0,
+				scratch,
+			)
+			return contribs, err
+		},
+	)
+}
+
+// runBatch calls predict for each of numRows rows, splitting the rows across
+// worker goroutines, and returns the results in row order. Each worker passes
+// its own PredictOptions and Scratch to predict.
+func (p *Predictor) runBatch(
+	ctx context.Context,
+	numRows int,
+	opts []BatchOption,
+	predict func(i int, po *PredictOptions, scratch *Scratch) ([]float32, error),
+) ([][]float32, error) {
+	var o BatchOptions
+	for _, f := range opts {
+		f(&o)
+	}
+	if o.workers < 1 {
+		o.workers = runtime.GOMAXPROCS(0)
+	}
+	workers := min(o.workers, numRows)
+
+	results := make([][]float32, numRows)
+
+	var (
+		next     atomic.Int64
+		failed   atomic.Bool
+		errOnce  sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			failed.Store(true)
+		})
+	}
+
+	for range workers {
+		wg.Go(func() {
+			var (
+				scratch Scratch
+				po      PredictOptions
+			)
+			for !failed.Load() {
+				i := int(next.Add(1) - 1)
+				if i >= numRows {
+					return
+				}
+
+				if err := ctx.Err(); err != nil {
+					fail(err)
+					return
+				}
+
+				contribs, err := predict(i, &po, &scratch)
+				if err != nil {
+					fail(fmt.Errorf("row %d: %w", i, err))
+					return
+				}
+				results[i] = contribs
+			}
+		})
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return results, nil
+}
@@ -0,0 +1,106 @@
+package xgbshap
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPredictContributionsBatch(t *testing.T) {
+	p, err := NewPredictor("testdata/roundtrip/model.json")
+	require.NoError(t, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
+	want := make([][]float32, len(allFeatures))
+	for i, features := range allFeatures {
+		want[i], err = p.PredictContributions(features)
+		require.NoError(t, err)
+	}
+
+	for _, workers := range []int{0, 1, 3, len(allFeatures) + 10} {
+		got, err := p.PredictContributionsBatch(
+			t.Context(),
+			allFeatures,
+			Workers(workers),
+		)
+		require.NoError(t, err, "workers=%d", workers)
+		assert.Equal(t, want, got, "workers=%d", workers)
+	}
+
+	t.Run("no rows", func(t *testing.T) {
+		got, err := p.PredictContributionsBatch(t.Context(), nil)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		_, err := p.PredictContributionsBatch(ctx, allFeatures)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("rejects multiple groups", func(t *testing.T) {
+		p, err := NewPredictor("testdata/multiclass/model.json")
+		require.NoError(t, err)
+
+		_, err = p.PredictContributionsBatch(
+			t.Context(),
+			[][]*float32{{toPtr(1), toPtr(1)}},
+		)
+		require.ErrorContains(t, err, "3 output groups")
+	})
+}
+
+// TestPredictorConcurrentUse exercises a shared Predictor from many
+// goroutines. Run it with the race detector to check that prediction does
+// not modify the Predictor.
+func TestPredictorConcurrentUse(t *testing.T) {
+	p, err := NewPredictor("testdata/roundtrip/model.json")
+	require.NoError(t, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
+	want, err := p.PredictContributionsBatch(t.Context(), allFeatures, Workers(1))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			got, err := p.PredictContributionsBatch(t.Context(), allFeatures)
+			assert.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+		wg.Go(func() {
+			for i, features := range allFeatures {
+				got, err := p.PredictContributions(features)
+				assert.NoError(t, err)
+				assert.Equal(t, want[i], got)
+
+				_, err = p.Predict(features)
+				assert.NoError(t, err)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func BenchmarkPredictContributionsBatch(b *testing.B) {
+	p, err := NewPredictor("testdata/roundtrip/model.json")
+	require.NoError(b, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(b, err)
+
+	for b.Loop() {
+		_, err := p.PredictContributionsBatch(b.Context(), allFeatures)
+		require.NoError(b, err)
+	}
+}
@@ -0,0 +1,122 @@
+package xgbshap
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// maxCategory is one more than the largest valid category. Categories are
+// stored as float32, which represents every integer up to 2^24 exactly.
+//
+// This is equivalent to OutOfRangeCat() in xgboost (categorical.h).
+const maxCategory = 1 << 24
+
+// CategoryError is returned by predictions using InvalidCategoryError when a
+// categorical feature's value is not a valid category.
+type CategoryError struct {
+	// Feature is the index of the feature.
+	Feature int
+	// Value is the feature's value.
+	Value float32
+}
+
+func (e *CategoryError) Error() string {
+	return fmt.Sprintf(
+		"feature %d is categorical but its value %v is not a valid category",
+		e.Feature,
+		e.Value,
+	)
+}
+
+// categoryInRange reports whether v may be treated as a category once
+// truncated to an integer. It is false for NaN.
+//
+// This is the inverse of InvalidCat() in xgboost (categorical.h).
+func categoryInRange(v float32) bool {
+	return v >= 0 && v < maxCategory
+}
+
+// isValidCategory reports whether v is exactly a valid category.
+func isValidCategory(v float32) bool {
+	return categoryInRange(v) && v == float32(math.Trunc(float64(v)))
+}
+
+// resolveCategoricalFeatures returns the indexes of the categorical features:
+// those marked "c" in feature_types and those that any tree has a categorical
+// split on. The latter covers models saved without feature types.
+func resolveCategoricalFeatures(
+	featureTypes []string,
+	numFeature int,
+	trees []*Tree,
+) ([]int, error) {
+	if len(featureTypes) != 0 && len(featureTypes) != numFeature {
+		return nil, fmt.Errorf(
+			"model has %d feature types but %d features",
+			len(featureTypes),
+			numFeature,
+		)
+	}
+
+	// numFeature may be large for sparse data, so the indexes are collected
+	// directly rather than by marking a slice of numFeature flags.
+	var indexes []int
+	for i, featureType := range featureTypes {
+		switch featureType {
+		case "c":
+			indexes = append(indexes, i)
+		case "float", "int", "i", "q":
+		default:
+			return nil, fmt.Errorf(
+				"feature %d has unknown feature type %q",
+				i,
+				featureType,
+			)
+		}
+	}
+
+	for _, tree := range trees {
+		for i := range tree.Nodes {
+			data := &tree.Nodes[i].Data
+			if data.Categorical {
+				indexes = append(indexes, data.SplitIndex)
+			}
+		}
+	}
+
+	slices.Sort(indexes)
+	return slices.Compact(indexes), nil
+}
+
+// checkCategories applies the Predictor's InvalidCategoryPolicy to the values
+// of the categorical features. It returns features unchanged unless the
+// policy is InvalidCategoryMissing and a value is invalid, in which case it
+// returns a copy with the invalid values replaced by nil.
+func (p *Predictor) checkCategories(features []*float32) ([]*float32, error) {
+	if p.invalidCategory == InvalidCategoryXGBoost {
+		return features, nil
+	}
+
+	checked := features
+	copied := false
+	for _, i := range p.categoricalFeatures {
+		v := features[i]
+		if isMissingValue(v) || isValidCategory(*v) {
+			continue
+		}
+
+		if p.invalidCategory == InvalidCategoryError {
+			return nil, &CategoryError{Feature: i, Value: *v}
+		}
+
+		// Copy on the first invalid value so that the common case of valid
+		// input does not allocate.
+		if !copied {
+			checked = slices.Clone(features)
+			copied = true
+		}
+		checked[i] = nil
+	}
+
+	return checked, nil
+}
@@ -0,0 +1,146 @@
+package xgbshap
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsValidCategory(t *testing.T) {
+	tests := []struct {
+		value   float32
+		inRange bool
+		valid   bool
+	}{
+		{value: 0, inRange: true, valid: true},
+		{value: 3, inRange: true, valid: true},
+		{value: maxCategory - 1, inRange: true, valid: true},
+		{value: 2.7, inRange: true, valid: false},
+		{value: -1, inRange: false, valid: false},
+		{value: -0.5, inRange: false, valid: false},
+		{value: maxCategory, inRange: false, valid: false},
+		{value: float32(math.Inf(1)), inRange: false, valid: false},
+		{value: float32(math.NaN()), inRange: false, valid: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.inRange, categoryInRange(test.value), "%v", test.value)
+		assert.Equal(t, test.valid, isValidCategory(test.value), "%v", test.value)
+	}
+}
+
+func TestResolveCategoricalFeatures(t *testing.T) {
+	t.Run("feature types", func(t *testing.T) {
+		p, err := NewPredictor("testdata/roundtrip/model.json")
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 3, 5}, p.categoricalFeatures)
+	})
+
+	t.Run("categorical splits without feature types", func(t *testing.T) {
+		p, err := NewPredictor("testdata/categorical/model.json")
+		require.NoError(t, err)
+		assert.Equal(t, []int{0}, p.categoricalFeatures)
+	})
+
+	t.Run("no categorical features", func(t *testing.T) {
+		indexes, err := resolveCategoricalFeatures(
+			[]string{"float", "int", "i", "q"},
+			4,
+			nil,
+		)
+		require.NoError(t, err)
+		assert.Empty(t, indexes)
+	})
+
+	t.Run("wrong number of feature types", func(t *testing.T) {
+		_, err := resolveCategoricalFeatures([]string{"c"}, 2, nil)
+		require.EqualError(t, err, "model has 1 feature types but 2 features")
+	})
+
+	t.Run("unknown feature type", func(t *testing.T) {
+		_, err := resolveCategoricalFeatures([]string{"q", "x"}, 2, nil)
+		require.EqualError(t, err, `feature 1 has unknown feature type "x"`)
+	})
+}
+
+func TestInvalidCategoryPolicies(t *testing.T) {
+	// The model's root splits on feature 0, sending categories 1 and 3 right
+	// (30) and everything else left (10). Missing values go left.
+	const (
+		left  = float32(10)
+		right = float32(30)
+	)
+
+	tests := []struct {
+		name  string
+		value float32
+		// xgboost and missing are the expected predictions with
+		// InvalidCategoryXGBoost and InvalidCategoryMissing. isError is
+		// whether InvalidCategoryError rejects the value.
+		xgboost float32
+		missing float32
+		isError bool
+	}{
+		{name: "valid", value: 3, xgboost: right, missing: right},
+		{name: "non-integer is truncated", value: 3.5, xgboost: right, missing: left, isError: true},
+		{name: "negative", value: -1, xgboost: left, missing: left, isError: true},
+		{name: "too large", value: 1e30, xgboost: left, missing: left, isError: true},
+		// NaN is a missing value rather than an invalid category.
+		{name: "NaN", value: float32(math.NaN()), xgboost: left, missing: left},
+	}
+
+	predictors := map[InvalidCategoryPolicy]*Predictor{}
+	for _, policy := range []InvalidCategoryPolicy{
+		InvalidCategoryXGBoost,
+		InvalidCategoryError,
+		InvalidCategoryMissing,
+	} {
+		p, err := NewPredictor(
+			"testdata/categorical/model.json",
+			OnInvalidCategory(policy),
+		)
+		require.NoError(t, err)
+		predictors[policy] = p
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			features := []*float32{toPtr(test.value)}
+
+			margin, err := predictors[InvalidCategoryXGBoost].PredictMargin(features)
+			require.NoError(t, err)
+			assert.Equal(t, []float32{test.xgboost}, margin)
+
+			margin, err = predictors[InvalidCategoryMissing].PredictMargin(features)
+			require.NoError(t, err)
+			assert.Equal(t, []float32{test.missing}, margin)
+
+			// The contributions must agree with the prediction.
+			contributions, err := predictors[InvalidCategoryMissing].PredictContributions(features)
+			require.NoError(t, err)
+			assert.InDelta(t, test.missing, contributions[0]+contributions[1], 1e-6)
+
+			_, err = predictors[InvalidCategoryError].PredictMargin(features)
+			if !test.isError {
+				require.NoError(t, err)
+				return
+			}
+			var categoryErr *CategoryError
+			require.ErrorAs(t, err, &categoryErr)
+			assert.Equal(t, 0, categoryErr.Feature)
+
+			_, err = predictors[InvalidCategoryError].PredictContributions(features)
+			require.ErrorAs(t, err, &categoryErr)
+		})
+	}
+
+	t.Run("missing policy does not modify the input", func(t *testing.T) {
+		features := []*float32{toPtr(-1)}
+		_, err := predictors[InvalidCategoryMissing].PredictMargin(features)
+		require.NoError(t, err)
+		require.NotNil(t, features[0])
+		assert.Equal(t, float32(-1), *features[0])
+	})
+}
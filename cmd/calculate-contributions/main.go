@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"errors"
 	"flag"
@@ -19,7 +20,7 @@ func main() {
 	modelFile := flag.String(
 		"model",
 		"",
-		"Path to the XGBoost model JSON file",
+		"Path to the XGBoost model file (JSON or UBJSON)",
 	)
 
 	featuresFile := flag.String(
@@ -84,17 +85,17 @@ func predictContributionsBatch(
 		return nil, nil, err
 	}
 
-	var contributionsSets [][]float32
-	for _, features := range featuresSets {
-		contribs, err := predictor.PredictContributions(features)
-		if err != nil {
-			return nil, nil, err
-		}
+	contributionsSets, err := predictor.PredictContributionsBatch(
+		context.Background(),
+		featuresSets,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		contributionsSets = append(
-			contributionsSets,
-			contribs[:len(contribs)-1], // Last value is not a contribution.
-		)
+	for i, contribs := range contributionsSets {
+		// Last value is not a contribution.
+		contributionsSets[i] = contribs[:len(contribs)-1]
 	}
 
 	return featuresSets, contributionsSets, nil
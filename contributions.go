@@ -8,20 +8,395 @@ package xgbshap
 
 import (
 	"fmt"
+	"math"
 	"slices"
 )
 
 // PredictContributions calculates the contributions of features.
+//
+// The returned slice has one element per feature followed by the bias. It
+// returns an error for models with more than one output group, such as
+// multi-class models; use PredictContributionsMulticlass for those.
 func (p *Predictor) PredictContributions(
 	features []*float32,
+	opts ...PredictOption,
 ) ([]float32, error) {
-	return predictContributions(
-		p.ntreeLimit,
-		p.trees,
+	if err := p.checkSingleGroup("PredictContributions"); err != nil {
+		return nil, err
+	}
+
+	o, err := p.applyPredictOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var condition, conditionFeature int
+
+	return p.predictContributions(
 		features,
+		&o,
+		false,
+		condition,
+		conditionFeature,
 	)
 }
 
+// PredictContributionsDense is like PredictContributions but takes the
+// features as values rather than pointers. A feature is missing if its value
+// is NaN or the value set with the Missing option.
+func (p *Predictor) PredictContributionsDense(
+	features []float32,
+	opts ...PredictOption,
+) ([]float32, error) {
+	return p.PredictContributions(p.denseFeatures(features), opts...)
+}
+
+// denseFeatures converts dense features into the pointer form used by the
+// other prediction methods, with nil for missing values. The pointers refer
+// to the elements of features, so only the slice of pointers is allocated.
+func (p *Predictor) denseFeatures(features []float32) []*float32 {
+	pointers := make([]*float32, len(features))
+	for i := range features {
+		if p.isMissingSentinel(features[i]) {
+			continue
+		}
+		pointers[i] = &features[i]
+	}
+	return pointers
+}
+
+// isMissingSentinel reports whether v is the value set with the Missing
+// option.
+func (p *Predictor) isMissingSentinel(v float32) bool {
+	return p.hasMissing && v == p.missing
+}
+
+// Scratch holds working memory for calculating contributions. Passing the
+// same Scratch to repeated PredictContributionsInto calls lets them run
+// without allocating once its buffers have grown to fit the model. The zero
+// value is ready to use.
+//
+// A Scratch may be reused across calls and Predictors, but it must not be
+// used by more than one goroutine at a time. A sync.Pool of *Scratch is a
+// convenient way to share them between goroutines.
+type Scratch struct {
+	contribs []float32
+	pathData []PathElement
+	// sparse holds the dense view of a sparse row. See fillSparse().
+	sparse []*float32
+}
+
+// contribsBuffer returns a zeroed buffer for one tree's contributions. A tree
+// only writes to the columns of its split features and the bias, so callers
+// reuse the buffer for each tree by zeroing just those columns.
+func (s *Scratch) contribsBuffer(n int) []float32 {
+	if cap(s.contribs) < n {
+		s.contribs = make([]float32, n)
+	}
+	buf := s.contribs[:n]
+	clear(buf)
+	return buf
+}
+
+// pathBuffer returns a zeroed buffer for the unique path data of a tree with
+// the given max depth.
+func (s *Scratch) pathBuffer(maxDepth int) []PathElement {
+	// Preallocate space for the unique path data
+	//
+	// I'm not sure what the +2 is for.
+	maxDepth += 2
+	n := (maxDepth * (maxDepth + 1)) / 2
+
+	if cap(s.pathData) < n {
+		s.pathData = make([]PathElement, n)
+	}
+	buf := s.pathData[:n]
+	clear(buf)
+	return buf
+}
+
+// PredictContributionsInto is like PredictContributions but stores the
+// contributions in dst, which must have a length of len(features)+1, and
+// uses scratch for working memory. With a reused scratch and no options, it
+// does not allocate. If scratch is nil, a new one is allocated for the call.
+func (p *Predictor) PredictContributionsInto(
+	dst []float32,
+	features []*float32,
+	scratch *Scratch,
+	opts ...PredictOption,
+) error {
+	if err := p.checkSingleGroup("PredictContributionsInto"); err != nil {
+		return err
+	}
+
+	if err := p.checkFeatureCount(len(features)); err != nil {
+		return err
+	}
+
+	if len(dst) != len(features)+1 {
+		return fmt.Errorf(
+			"dst has length %d; expected %d (number of features + 1)",
+			len(dst),
+			len(features)+1,
+		)
+	}
+
+	o, err := p.applyPredictOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	if scratch == nil {
+		scratch = &Scratch{}
+	}
+
+	return p.predictContributionsInto(dst, features, &o, false, 0, 0, scratch)
+}
+
+// PredictApproxContributions calculates approximate contributions of features
+// using the Saabas method, the equivalent of XGBoost's approx_contribs=True.
+// Each split's change in the expected value of the tree is attributed to the
+// split's feature along the path the features take. This is much cheaper
+// than TreeSHAP, which PredictContributions uses, but the result is not a
+// Shapley value. Contributions still sum to the margin.
+//
+// The result has the same layout as PredictContributions, and it likewise
+// returns an error for models with more than one output group.
+func (p *Predictor) PredictApproxContributions(
+	features []*float32,
+	opts ...PredictOption,
+) ([]float32, error) {
+	if err := p.checkSingleGroup("PredictApproxContributions"); err != nil {
+		return nil, err
+	}
+
+	o, err := p.applyPredictOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.predictContributions(features, &o, true, 0, 0)
+}
+
+// PredictConditionalContributions calculates the contributions of the other
+// features when conditionFeature is fixed as present (condition 1) or as
+// absent (condition -1). When it is present, the features follow its split
+// as they would without conditioning; when it is absent, both sides of its
+// splits are weighted by their share of the training data, as for a missing
+// value. This answers "what would the other features contribute if this
+// feature were absent?"
+//
+// The result has the same layout as PredictContributions. The condition
+// feature's own contribution is zero, and the bias only holds the base
+// score, as the trees' expected values are not added when conditioning. Half
+// of the difference between the two conditions is the interaction between
+// conditionFeature and each other feature that PredictInteractions returns.
+//
+// Like PredictContributions, it returns an error for models with more than
+// one output group.
+func (p *Predictor) PredictConditionalContributions(
+	features []*float32,
+	conditionFeature,
+	condition int,
+	opts ...PredictOption,
+) ([]float32, error) {
+	if err := p.checkSingleGroup("PredictConditionalContributions"); err != nil {
+		return nil, err
+	}
+
+	if condition != 1 && condition != -1 {
+		return nil, fmt.Errorf(
+			"condition must be 1 (present) or -1 (absent), got %d",
+			condition,
+		)
+	}
+
+	if conditionFeature < 0 || conditionFeature >= p.numFeature {
+		return nil, fmt.Errorf(
+			"condition feature %d is out of range for %d features",
+			conditionFeature,
+			p.numFeature,
+		)
+	}
+
+	o, err := p.applyPredictOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.predictContributions(
+		features,
+		&o,
+		false,
+		condition,
+		conditionFeature,
+	)
+}
+
+// PredictContributionsMulticlass calculates the contributions of features for
+// each output group of the model. For multi-class models there is one group per
+// class, so the result is indexed by class and then by feature, with the bias
+// as the last element of each class's slice. For other models the result has a
+// single element equal to what PredictContributions returns.
+func (p *Predictor) PredictContributionsMulticlass(
+	features []*float32,
+	opts ...PredictOption,
+) ([][]float32, error) {
+	o, err := p.applyPredictOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var condition, conditionFeature int
+
+	contribs, err := p.predictContributions(
+		features,
+		&o,
+		false,
+		condition,
+		conditionFeature,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitRows(contribs, len(features)+1), nil
+}
+
+// PredictInteractions calculates SHAP interaction values, the equivalent of
+// XGBoost's pred_interactions=True. The result is a (number of features + 1)
+// square matrix where the element at [i][j], i != j, is half of the
+// interaction effect between features i and j, and the diagonal holds each
+// feature's main effect. Row i sums to feature i's contribution. The last row
+// and column are for the bias: only their last element is non-zero, and it is
+// the bias.
+//
+// Like PredictContributions, it returns an error for models with more than
+// one output group.
+func (p *Predictor) PredictInteractions(
+	features []*float32,
+	opts ...PredictOption,
+) ([][]float32, error) {
+	if err := p.checkSingleGroup("PredictInteractions"); err != nil {
+		return nil, err
+	}
+
+	// Check this before predictInteractions allocates its matrix, whose size
+	// is quadratic in the number of features.
+	if err := p.checkFeatureCount(len(features)); err != nil {
+		return nil, err
+	}
+
+	o, err := p.applyPredictOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	interactions, err := p.predictInteractions(features, &o)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitRows(interactions, len(features)+1), nil
+}
+
+// splitRows splits a flat slice into rows of length n without copying.
+func splitRows(flat []float32, n int) [][]float32 {
+	rows := make([][]float32, len(flat)/n)
+	for i := range rows {
+		rows[i] = flat[i*n : (i+1)*n : (i+1)*n]
+	}
+	return rows
+}
+
+// Calculate SHAP interaction values. The interaction between features i and
+// j is the difference in feature j's contribution between conditioning on
+// feature i being present and on it being absent.
+//
+// See: Axiomatic characterizations of probabilistic and cardinal-probabilistic
+// interaction indices.
+//
+// The result holds a (number of features + bias) square matrix for each
+// output group, laid out group by group and then row by row as in xgboost.
+//
+// This is equivalent to PredictInteractionContributions() in xgboost.
+func (p *Predictor) predictInteractions(
+	features []*float32,
+	o *PredictOptions,
+) ([]float32, error) {
+	nColumns := len(features) + 1
+	matrixSize := nColumns * nColumns
+
+	contribs := make([]float32, p.numGroup*matrixSize)
+
+	s := &Scratch{}
+	contribsDiag := make([]float32, p.numGroup*nColumns)
+	contribsOff := make([]float32, p.numGroup*nColumns)
+	contribsOn := make([]float32, p.numGroup*nColumns)
+
+	// Compute the difference in effects when conditioning on each of the
+	// features on and off.
+	err := p.predictContributionsInto(
+		contribsDiag,
+		features,
+		o,
+		false,
+		0,
+		0,
+		s,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range nColumns {
+		err := p.predictContributionsInto(
+			contribsOff,
+			features,
+			o,
+			false,
+			-1,
+			i,
+			s,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.predictContributionsInto(
+			contribsOn,
+			features,
+			o,
+			false,
+			1,
+			i,
+			s,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for l := range p.numGroup {
+			oOffset := l*matrixSize + i*nColumns
+			cOffset := l * nColumns
+
+			contribs[oOffset+i] = 0
+			for k := range nColumns {
+				// fill in the diagonal with additive effects, and off-diagonal
+				// with the interactions
+				if k == i {
+					contribs[oOffset+i] += contribsDiag[cOffset+k]
+				} else {
+					contribs[oOffset+k] = (contribsOn[cOffset+k] - contribsOff[cOffset+k]) / 2
+					contribs[oOffset+i] -= contribs[oOffset+k]
+				}
+			}
+		}
+	}
+
+	return contribs, nil
+}
+
 // Calculate the contributions of features.
 //
 // This is a port of the xgboost code. Specifically, the flow we're porting is
@@ -36,12 +411,36 @@ func (p *Predictor) PredictContributions(
 //   - This calls CalculateContributions() in cpu_treeshap.cc, which is the main
 //     algorithm for calculating contributions.
 //
+// The result is stored in contribs, which holds (number of features + bias)
+// values for each output group, laid out group by group as in xgboost.
+//
+// approximate selects the Saabas method rather than TreeSHAP. condition and
+// conditionFeature fix a feature as present (condition 1) or absent
+// (condition -1) in every coalition. With condition 0, the usual
+// unconditional contributions are calculated.
+//
+// contribs must have room for (number of features + bias) values for each
+// output group.
+//
 // This function is equivalent to PredictContribution() in xgboost.
-func predictContributions(
-	ntreeLimit int,
-	trees []*Tree,
+func (p *Predictor) predictContributionsInto(
+	contribs []float32,
 	features []*float32,
-) ([]float32, error) {
+	o *PredictOptions,
+	approximate bool,
+	condition,
+	conditionFeature int,
+	s *Scratch,
+) error {
+	if err := p.checkFeatureCount(len(features)); err != nil {
+		return err
+	}
+
+	features, err := p.checkCategories(features)
+	if err != nil {
+		return err
+	}
+
 	// The main entrypoint is the call to Predict():
 	//
 	// In the C++ code, iterationEnd gets set by a function. However that
@@ -59,74 +458,145 @@ func predictContributions(
 	// Its parameters:
 	// - data.get is our input features (data matrix)
 	// - out_preds is an array of floats (where we store the output)
-	// - layer_begin = 0
-	// - layer_end = iterationEnd
-	// - approx_contrib is a bool and it's always false in our calls
+	// - layer_begin and layer_end select the boosting rounds to use
+	// - approx_contrib selects CalculateContributionsApprox()
 	// gbm_->PredictContribution(data.get(), out_preds, layer_begin, layer_end, approx_contribs);
 
-	// ngroup seems to always be 1.
+	// Each boosting round (layer) adds numParallelTree trees per output
+	// group. treeRange converts the selected rounds into a range of trees.
+	treeBegin, treeEnd := p.treeRange(o)
 
-	// allocate space for (number of features + bias) times the number of rows
+	// contribs has space for (number of features + bias) times the number of
+	// groups
 	//
 	// +1 for "bias" (xgboost's term in its source) or "intercept term" (what we
-	// refer to it in our Perl code). It's an extra value we get when calculating
-	// contributions, which we don't use.
+	// refer to it in our Perl code). It holds the expected value of the
+	// trees plus the base margin.
 	nColumns := len(features) + 1
 
-	contribs := make([]float32, nColumns)
-
-	// Initialize tree node mean values.
-	meanValues := make([][]float32, ntreeLimit)
-	for i := range ntreeLimit {
-		meanValues[i] = make([]float32, trees[i].NumNodes)
+	clear(contribs)
 
-		nodeIndex := 0
-		fillNodeMeanValues(trees[i], nodeIndex, meanValues[i])
-	}
-
-	// base_score/base_margin seem to only be used for calculating the
-	// bias/intercept, so I'm ignoring them for now as we don't use those as far
-	// as I know.
+	// The tree node mean values are initialized once, when the Predictor is
+	// created. See fillDerivedValues().
 
 	// The C++ code processes the features in batches (the GetBatches() loop). In
 	// the case where we're calculating contributions for one feature set,
 	// there's only one batch, so we don't need to worry about that.
 
-	// If ngroup was not 1, then we'd need an additional loop here.
+	treeContribs := s.contribsBuffer(nColumns)
+
+	for gid := range p.numGroup {
+		groupContribs := contribs[gid*nColumns : (gid+1)*nColumns]
 
-	for i := range ntreeLimit {
-		treeMeanValues := meanValues[i]
+		for i := treeBegin; i < treeEnd; i++ {
+			// Only the trees of the current group contribute to it.
+			if p.treeGroups[i] != gid {
+				continue
+			}
 
-		treeContribs := make([]float32, nColumns)
+			treeMeanValues := p.trees[i].meanValues
+
+			if approximate {
+				calculateContributionsApprox(
+					p.trees[i],
+					features,
+					treeMeanValues,
+					treeContribs,
+				)
+			} else {
+				err := calculateContributions(
+					p.trees[i],
+					features,
+					treeMeanValues,
+					treeContribs,
+					s.pathBuffer(p.trees[i].maxDepth),
+					condition,
+					conditionFeature,
+				)
+				if err != nil {
+					return err
+				}
+			}
 
-		// I'm not sure what condition and condition_feature parameters are. They
-		// are 0 in my testing.
-		var condition, conditionFeature int
+			// Only DART models have tree weights.
+			treeWeight := p.treeWeight(i)
 
-		err := calculateContributions(
-			trees[i],
-			features,
-			treeMeanValues,
-			treeContribs,
-			condition,
-			conditionFeature,
-		)
-		if err != nil {
-			return nil, err
+			// Add and zero only the columns the tree wrote, so that a tree
+			// costs time in proportion to the features it splits on rather
+			// than to all of the model's features.
+			for _, ci := range p.trees[i].splitFeatures {
+				groupContribs[ci] += treeContribs[ci] * treeWeight
+				treeContribs[ci] = 0
+			}
+			groupContribs[nColumns-1] += treeContribs[nColumns-1] * treeWeight
+			treeContribs[nColumns-1] = 0
 		}
 
-		for ci := range nColumns {
-			// tree_weights is null in my testing, so I'm ignoring it.
-			contribs[ci] += treeContribs[ci]
+		// add base margin to BIAS
+		if o.baseMargin != nil {
+			groupContribs[nColumns-1] += o.baseMargin[gid]
+		} else {
+			groupContribs[nColumns-1] += p.baseMargin[gid]
 		}
+	}
+
+	// Conditional contributions do not sum to the margin.
+	if p.verifyAdditivity && condition == 0 {
+		return p.checkAdditivity(contribs, features, o)
+	}
+
+	return nil
+}
 
-		// As mentioned above, since we don't use bias/intercept, I omit the code
-		// for that.
+// predictContributions is predictContributionsInto with a newly allocated
+// result.
+func (p *Predictor) predictContributions(
+	features []*float32,
+	o *PredictOptions,
+	approximate bool,
+	condition,
+	conditionFeature int,
+) ([]float32, error) {
+	contribs := make([]float32, p.numGroup*(len(features)+1))
+
+	err := p.predictContributionsInto(
+		contribs,
+		features,
+		o,
+		approximate,
+		condition,
+		conditionFeature,
+		&Scratch{},
+	)
+	if err != nil {
+		return nil, err
 	}
 
 	return contribs, nil
 }
 
+// fillDerivedValues computes and stores the values that depend only on the
+// tree: its node mean values, its max depth, and the features it splits on.
+func (t *Tree) fillDerivedValues() {
+	// Initialize tree node mean values.
+	t.meanValues = make([]float32, t.NumNodes)
+
+	nodeIndex := 0
+	fillNodeMeanValues(t, nodeIndex, t.meanValues)
+
+	t.maxDepth = t.Nodes[0].MaxDepth()
+
+	seen := map[int]bool{}
+	for i := range t.Nodes {
+		node := &t.Nodes[i]
+		if node.IsLeaf() || seen[node.Data.SplitIndex] {
+			continue
+		}
+		seen[node.Data.SplitIndex] = true
+		t.splitFeatures = append(t.splitFeatures, node.Data.SplitIndex)
+	}
+}
+
 // This is equivalent to the two FillNodeMeanValues() functions in xgboost.
 func fillNodeMeanValues(
 	tree *Tree,
@@ -136,9 +606,14 @@ func fillNodeMeanValues(
 	node := tree.Nodes[nodeIndex]
 
 	var result float32
-	if node.IsLeaf() {
+	switch {
+	case node.IsLeaf():
 		result = node.LeafValue()
-	} else {
+	case node.Data.SumHessian == 0:
+		// See childFractions.
+		result = (fillNodeMeanValues(tree, node.Left.Data.ID, meanValues) +
+			fillNodeMeanValues(tree, node.Right.Data.ID, meanValues)) / 2
+	default:
 		result = fillNodeMeanValues(
 			tree,
 			node.Left.Data.ID,
@@ -159,6 +634,64 @@ func fillNodeMeanValues(
 	return result
 }
 
+// childFractions returns the shares of node's sum of hessians held by its
+// children hot and cold, which TreeSHAP uses as the probabilities of reaching
+// them. XGBoost divides by the node's sum even when it is zero, which can
+// happen after pruning or with zero instance weights, giving NaN. Instead,
+// the children of such a node are weighted evenly, so that the model's
+// margins, which do not depend on the sums, remain available and its
+// contributions are still finite.
+func childFractions(node, hot, cold *Node) (float32, float32) {
+	w := node.Data.SumHessian
+	if w == 0 {
+		return 0.5, 0.5
+	}
+	return hot.Data.SumHessian / w, cold.Data.SumHessian / w
+}
+
+// This follows the idea of http://blog.datadive.net/interpreting-random-forests/
+//
+// This is equivalent to CalculateContributionsApprox() in xgboost.
+func calculateContributionsApprox(
+	tree *Tree,
+	features []*float32,
+	meanValues,
+	contribs []float32,
+) {
+	var splitIndex int
+
+	// update bias value
+	nodeValue := meanValues[0]
+	contribs[len(features)] += nodeValue
+	if tree.Nodes[0].IsLeaf() {
+		// nothing to do anymore
+		return
+	}
+
+	nodeIndex := 0
+	for !tree.Nodes[nodeIndex].IsLeaf() {
+		node := &tree.Nodes[nodeIndex]
+		splitIndex = node.Data.SplitIndex
+		featureValue := features[splitIndex]
+		nodeIndex = getNextNode(
+			true, // We always can have missing values.
+			node,
+			nodeIndex,
+			featureValue,
+			isMissingValue(featureValue),
+		)
+
+		newValue := meanValues[nodeIndex]
+		// update feature weight
+		contribs[splitIndex] += newValue - nodeValue
+		nodeValue = newValue
+	}
+
+	leafValue := tree.Nodes[nodeIndex].LeafValue()
+	// update leaf feature weight
+	contribs[splitIndex] += leafValue - nodeValue
+}
+
 // PathElement is an element used by the treeshap algorithm.
 type PathElement struct {
 	FeatureIndex int
@@ -168,11 +701,15 @@ type PathElement struct {
 }
 
 // This is equivalent to CalculateContributions() in xgboost.
+//
+// uniquePathData must have room for the tree's unique path data. See
+// Scratch.pathBuffer().
 func calculateContributions(
 	tree *Tree,
 	features []*float32,
 	meanValues,
 	contribs []float32,
+	uniquePathData []PathElement,
 	condition,
 	conditionFeature int,
 ) error {
@@ -182,12 +719,6 @@ func calculateContributions(
 		contribs[len(features)] += nodeValue
 	}
 
-	// Preallocate space for the unique path data
-	//
-	// I'm not sure what the +2 is for.
-	maxDepth := tree.Nodes[0].MaxDepth() + 2
-	uniquePathData := make([]PathElement, (maxDepth*(maxDepth+1))/2)
-
 	var nodeIndex, uniqueDepth int
 	parentZeroFraction := float32(1)
 	parentOneFraction := float32(1)
@@ -272,14 +803,13 @@ func treeShap(
 
 	// find which branch is "hot" (meaning x would follow it)
 
-	hasMissing := true                       // We always can have missing values.
-	isMissing := features[splitIndex] == nil // nil means missing.
+	hasMissing := true // We always can have missing values.
 	hotIndex := getNextNode(
 		hasMissing,
 		&node,
 		nodeIndex,
 		features[splitIndex],
-		isMissing,
+		isMissingValue(features[splitIndex]),
 	)
 
 	var coldIndex int
@@ -289,9 +819,11 @@ func treeShap(
 		coldIndex = node.Left.Data.ID
 	}
 
-	w := node.Data.SumHessian
-	hotZeroFraction := tree.Nodes[hotIndex].Data.SumHessian / w
-	coldZeroFraction := tree.Nodes[coldIndex].Data.SumHessian / w
+	hotZeroFraction, coldZeroFraction := childFractions(
+		&node,
+		&tree.Nodes[hotIndex],
+		&tree.Nodes[coldIndex],
+	)
 
 	incomingZeroFraction := float32(1)
 	incomingOneFraction := float32(1)
@@ -439,6 +971,12 @@ func unwoundPathSum(
 	return total, nil
 }
 
+// isMissingValue reports whether a feature value is missing. As in xgboost,
+// where NaN is the default missing value, both nil and NaN are missing.
+func isMissingValue(featureValue *float32) bool {
+	return featureValue == nil || math.IsNaN(float64(*featureValue))
+}
+
 // This is equivalent to GetNextNode() in xgboost (predict_fn.h).
 func getNextNode(
 	hasMissing bool,
@@ -455,19 +993,24 @@ func getNextNode(
 	}
 
 	if node.Data.Categorical {
-		// Categories contains the values that route to the right child.
-		if slices.Contains(node.Data.Categories, int(*featureValue)) {
+		// Categories contains the values that route to the right child. As in
+		// xgboost's Decision() (categorical.h), values that cannot be
+		// categories go left, and other values are truncated to an integer.
+		if categoryInRange(*featureValue) &&
+			slices.Contains(node.Data.Categories, int(*featureValue)) {
 			return node.Right.Data.ID
 		}
 		return node.Left.Data.ID
 	}
 
-	nextNodeIndex := node.Left.Data.ID
-	if !(*featureValue < node.Data.SplitCondition) {
-		nextNodeIndex++
+	// xgboost returns the left child's index plus one for the right child as
+	// its trees always allocate children in adjacent slots. We follow the
+	// Right pointer instead so that we do not depend on that layout.
+	if *featureValue < node.Data.SplitCondition {
+		return node.Left.Data.ID
 	}
 
-	return nextNodeIndex
+	return node.Right.Data.ID
 }
 
 // undo a previous extension of the decision path
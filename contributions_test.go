@@ -2,7 +2,9 @@ package xgbshap
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io/fs"
 	"math"
 	"os"
 	"strconv"
@@ -52,6 +54,15 @@ func TestPredictContributions(t *testing.T) {
 	contributions, err := p.PredictContributions(features)
 	require.NoError(t, err)
 
+	t.Run("base margin is added to the bias", func(t *testing.T) {
+		withMargin, err := p.PredictContributions(features, BaseMargin(2))
+		require.NoError(t, err)
+
+		bias := len(contributions) - 1
+		assert.Equal(t, contributions[:bias], withMargin[:bias])
+		assert.InDelta(t, contributions[bias]+2, withMargin[bias], 1e-6)
+	})
+
 	assert.Equal(
 		t,
 		[]float32{
@@ -147,15 +158,568 @@ func TestPredictContributionsNegInfSplit(t *testing.T) {
 		// contribution=-5, bias=15; sum = 10 = left leaf.
 		assert.Equal(t, []float32{-5.0}, contributions[:len(contributions)-1])
 	})
+
+	t.Run("NaN feature is missing and routes left", func(t *testing.T) {
+		contributions, err := p.PredictContributions(
+			[]*float32{toPtr(float32(math.NaN()))},
+		)
+		require.NoError(t, err)
+
+		assert.Equal(t, []float32{-5.0}, contributions[:len(contributions)-1])
+	})
 }
 
-func TestPredictContributionsRoundtrip(t *testing.T) {
+func TestPredictContributionsDense(t *testing.T) {
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
+	toDense := func(features []*float32, missing float32) []float32 {
+		dense := make([]float32, len(features))
+		for i, f := range features {
+			if f == nil {
+				dense[i] = missing
+			} else {
+				dense[i] = *f
+			}
+		}
+		return dense
+	}
+
+	t.Run("NaN is missing", func(t *testing.T) {
+		p, err := NewPredictor("testdata/roundtrip/model.json")
+		require.NoError(t, err)
+
+		for row, features := range allFeatures[:20] {
+			want, err := p.PredictContributions(features)
+			require.NoError(t, err)
+
+			got, err := p.PredictContributionsDense(
+				toDense(features, float32(math.NaN())),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, want, got, "row %d", row)
+		}
+	})
+
+	t.Run("Missing sets a sentinel", func(t *testing.T) {
+		p, err := NewPredictor("testdata/roundtrip/model.json", Missing(-999))
+		require.NoError(t, err)
+
+		for row, features := range allFeatures[:20] {
+			want, err := p.PredictContributions(features)
+			require.NoError(t, err)
+
+			got, err := p.PredictContributionsDense(toDense(features, -999))
+			require.NoError(t, err)
+			assert.Equal(t, want, got, "row %d", row)
+
+			// NaN is still missing.
+			got, err = p.PredictContributionsDense(
+				toDense(features, float32(math.NaN())),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, want, got, "row %d", row)
+		}
+	})
+
+	t.Run("sentinel is not missing by default", func(t *testing.T) {
+		p, err := NewPredictor("testdata/neg-inf-split/model.json")
+		require.NoError(t, err)
+
+		contributions, err := p.PredictContributionsDense([]float32{-999})
+		require.NoError(t, err)
+		assert.Equal(t, []float32{5.0}, contributions[:1])
+	})
+}
+
+func TestPredictContributionsDART(t *testing.T) {
+	// This DART model has two stumps on feature 0 with weight_drop
+	// [0.5, 2]. The first has leaves 1 and 3 (mean 2) and the second has
+	// leaves -1 and 1 (mean 0). Each tree's contributions, including its mean
+	// value in the bias, are scaled by its weight, so the bias is
+	// 0.5*2 + 2*0 = 1.
+	p, err := NewPredictor("testdata/dart/model.json")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		feature float32
+		want    []float32
+	}{
+		// 0.5*(3-2) + 2*(1-0) = 2.5; margin = 0.5*3 + 2*1 = 3.5.
+		{name: "right", feature: 1, want: []float32{2.5, 1}},
+		// 0.5*(1-2) + 2*(-1-0) = -2.5; margin = 0.5*1 + 2*(-1) = -1.5.
+		{name: "left", feature: 0, want: []float32{-2.5, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			features := []*float32{toPtr(test.feature)}
+
+			contributions, err := p.PredictContributions(features)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, contributions)
+
+			approx, err := p.PredictApproxContributions(features)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, approx)
+
+			margin, err := p.PredictMargin(features)
+			require.NoError(t, err)
+			assert.Equal(t, []float32{test.want[0] + test.want[1]}, margin)
+		})
+	}
+}
+
+func TestPredictContributionsMulticlass(t *testing.T) {
+	// This model has three classes and two boosting rounds, so six trees with
+	// tree_info [0, 1, 2, 0, 1, 2]. Every tree is a stump or a single leaf, so
+	// each split feature's contribution is its leaf value minus the tree's mean
+	// value, and the bias is the sum of the mean values plus the base_score of
+	// 0.5, which multi:softprob does not transform.
+	p, err := NewPredictor("testdata/multiclass/model.json")
+	require.NoError(t, err)
+
+	features := []*float32{toPtr(1.0), toPtr(0.5)}
+
+	contributions, err := p.PredictContributionsMulticlass(features)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		[][]float32{
+			{-0.5, 0.25, 0.5},
+			{0.375, -0.5, 1.5},
+			{-2.0, 0, 0.75},
+		},
+		contributions,
+	)
+
+	t.Run("PredictContributions rejects multiple groups", func(t *testing.T) {
+		_, err := p.PredictContributions(features)
+		require.EqualError(
+			t,
+			err,
+			"model has 3 output groups; PredictContributions requires exactly one",
+		)
+	})
+
+	t.Run("ntree limit counts boosting rounds", func(t *testing.T) {
+		p, err := NewPredictor("testdata/multiclass/model.json", NtreeLimit(1))
+		require.NoError(t, err)
+
+		contributions, err := p.PredictContributionsMulticlass(features)
+		require.NoError(t, err)
+
+		assert.Equal(
+			t,
+			[][]float32{
+				{-0.5, 0, 0},
+				{0, -0.5, 1.5},
+				{-2.0, 0, 0.5},
+			},
+			contributions,
+		)
+	})
+
+	t.Run("base margin replaces base_score", func(t *testing.T) {
+		contributions, err := p.PredictContributionsMulticlass(
+			features,
+			BaseMargin(1, 2, 3),
+		)
+		require.NoError(t, err)
+
+		assert.Equal(
+			t,
+			[][]float32{
+				{-0.5, 0.25, 1},
+				{0.375, -0.5, 3},
+				{-2.0, 0, 3.25},
+			},
+			contributions,
+		)
+	})
+
+	t.Run("base margin must cover every group", func(t *testing.T) {
+		_, err := p.PredictContributionsMulticlass(features, BaseMargin(1))
+		require.ErrorContains(t, err, "1 base margin values")
+	})
+
+	t.Run("single group models return one group", func(t *testing.T) {
+		p, err := NewPredictor("testdata/categorical/model.json")
+		require.NoError(t, err)
+
+		features := []*float32{toPtr(1.0)}
+
+		want, err := p.PredictContributions(features)
+		require.NoError(t, err)
+
+		got, err := p.PredictContributionsMulticlass(features)
+		require.NoError(t, err)
+		assert.Equal(t, [][]float32{want}, got)
+	})
+}
+
+func TestPredictContributionsRandomForest(t *testing.T) {
+	// These models have num_parallel_tree=2, so each boosting round has two
+	// trees per output group. Every tree is a stump on a split at 0.5 with
+	// equal hessians, so its mean value is the mean of its leaves.
+	t.Run("regressor", func(t *testing.T) {
+		// Two rounds: trees 0 and 1 (feature 0 with leaves 1/3, feature 1
+		// with leaves -2/2) and trees 2 and 3 (leaves 10/20 each).
+		// best_iteration=0 selects the first round's two trees.
+		p, err := NewPredictor("testdata/rf-regressor/model.json")
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.layerEnd)
+		treeBegin, treeEnd := p.treeRange(&PredictOptions{})
+		assert.Equal(t, 0, treeBegin)
+		assert.Equal(t, 2, treeEnd)
+
+		features := []*float32{toPtr(1), toPtr(0)}
+
+		contributions, err := p.PredictContributions(features)
+		require.NoError(t, err)
+		// f0 = 3 - 2, f1 = -2 - 0, bias = 0.5 + 2 + 0.
+		assert.Equal(t, []float32{1, -2, 2.5}, contributions)
+
+		margin, err := p.PredictMargin(features)
+		require.NoError(t, err)
+		assert.Equal(t, []float32{1.5}, margin)
+
+		// The ntree limit counts trees per group, so both rounds are 4.
+		p, err = NewPredictor("testdata/rf-regressor/model.json", NtreeLimit(4))
+		require.NoError(t, err)
+
+		contributions, err = p.PredictContributions(features)
+		require.NoError(t, err)
+		// f0 = 1 + (20 - 15), f1 = -2 + (10 - 15), bias = 2.5 + 15 + 15.
+		assert.Equal(t, []float32{6, -7, 32.5}, contributions)
+
+		// As in XGBoost, a limit that is not a whole number of rounds is
+		// rounded down, so 3 selects the first round.
+		p, err = NewPredictor("testdata/rf-regressor/model.json", NtreeLimit(3))
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.layerEnd)
+	})
+
+	t.Run("classifier", func(t *testing.T) {
+		// One round of three classes with two trees each, all on feature 0,
+		// and a base_score of 0.5 for each class.
+		p, err := NewPredictor("testdata/rf-classifier/model.json")
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.layerEnd)
+		treeBegin, treeEnd := p.treeRange(&PredictOptions{})
+		assert.Equal(t, 0, treeBegin)
+		assert.Equal(t, 6, treeEnd)
+
+		features := []*float32{toPtr(1)}
+
+		contributions, err := p.PredictContributionsMulticlass(features)
+		require.NoError(t, err)
+		assert.Equal(
+			t,
+			[][]float32{
+				// (3 - 2) + (1 - 0), bias 0.5 + 2 + 0.
+				{2, 2.5},
+				// (2 - 1) + (4 - 3), bias 0.5 + 1 + 3.
+				{2, 4.5},
+				// (-1 - -2) + (-1 - 0), bias 0.5 - 2 + 0.
+				{0, -1.5},
+			},
+			contributions,
+		)
+
+		margin, err := p.PredictMargin(features)
+		require.NoError(t, err)
+		assert.Equal(t, []float32{4.5, 6.5, -1.5}, margin)
+	})
+}
+
+func TestPredictContributionsRandomForestRoundtrip(t *testing.T) {
+	const script = "testdata/generate-rf-models.py"
+
+	for _, dir := range []string{"xgbrf-regressor", "xgbrf-classifier"} {
+		t.Run(dir, func(t *testing.T) {
+			dir := "testdata/" + dir + "/"
+			skipWithoutFixture(t, dir+"contributions.csv", script)
+
+			p, err := NewPredictor(dir + "model.json")
+			require.NoError(t, err)
+
+			allFeatures, err := readFeaturesCSV(dir + "features.csv")
+			require.NoError(t, err)
+
+			allContribs, err := readContributionsCSV(dir + "contributions.csv")
+			require.NoError(t, err)
+			require.Len(t, allContribs, len(allFeatures))
+
+			for row, features := range allFeatures {
+				// The classifier's golden rows hold each class's
+				// contributions in turn.
+				groups, err := p.PredictContributionsMulticlass(features)
+				require.NoError(t, err)
+
+				var got []float32
+				for _, g := range groups {
+					got = append(got, g...)
+				}
+				assertMatchesGolden(t, allContribs[row], got, 1e-5, fmt.Sprintf("row %d", row))
+			}
+		})
+	}
+}
+
+func TestPredictInteractions(t *testing.T) {
+	// This model's single tree outputs 4 when both features are at least 0.5
+	// and 0 otherwise, with equal hessians on each side of each split. With
+	// x = (1, 1), the path-dependent expectations are E[f] = 1,
+	// E[f | x0] = E[f | x1] = 2, and E[f | x0, x1] = 4. The interaction is
+	// (4 - 2 - 2 + 1) / 2 = 0.5 and each feature's contribution is 1.5, so
+	// each main effect is 1.5 - 0.5 = 1.
+	p, err := NewPredictor("testdata/interaction/model.json")
+	require.NoError(t, err)
+
+	interactions, err := p.PredictInteractions([]*float32{toPtr(1), toPtr(1)})
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		[][]float32{
+			{1, 0.5, 0},
+			{0.5, 1, 0},
+			{0, 0, 1},
+		},
+		interactions,
+	)
+
+	t.Run("rejects multiple groups", func(t *testing.T) {
+		p, err := NewPredictor("testdata/multiclass/model.json")
+		require.NoError(t, err)
+
+		_, err = p.PredictInteractions([]*float32{toPtr(1), toPtr(1)})
+		require.ErrorContains(t, err, "3 output groups")
+	})
+}
+
+func TestPredictInteractionsRowsSumToContributions(t *testing.T) {
+	// The rows of XGBoost's interaction matrix sum to its contributions, so
+	// this checks the interactions against XGBoost's golden contributions.
 	p, err := NewPredictor("testdata/roundtrip/model.json")
 	require.NoError(t, err)
 
 	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
 	require.NoError(t, err)
 
+	allContribs, err := readContributionsCSV("testdata/roundtrip/contributions.csv")
+	require.NoError(t, err)
+	require.Len(t, allContribs, len(allFeatures))
+
+	for row, features := range allFeatures[:20] {
+		contributions := allContribs[row]
+
+		interactions, err := p.PredictInteractions(features)
+		require.NoError(t, err)
+		require.Len(t, interactions, len(contributions))
+
+		for i, interactionRow := range interactions {
+			var sum float32
+			for j, v := range interactionRow {
+				sum += v
+				assert.InDelta(
+					t,
+					v,
+					interactions[j][i],
+					1e-5,
+					"row %d: interactions should be symmetric", row,
+				)
+			}
+			assert.InDelta(t, contributions[i], sum, 1e-5, "row %d, feature %d", row, i)
+		}
+	}
+}
+
+func TestPredictConditionalContributions(t *testing.T) {
+	// See TestPredictInteractions for this model. With x0 fixed as present,
+	// x1's contribution is E[f | x0, x1] - E[f | x0] = 4 - 2. With x0 fixed
+	// as absent, it is E[f | x1] - E[f] = 2 - 1. Half of the difference is
+	// the interaction of 0.5. The bias is the base score of 0 in both cases.
+	p, err := NewPredictor("testdata/interaction/model.json")
+	require.NoError(t, err)
+
+	features := []*float32{toPtr(1), toPtr(1)}
+
+	on, err := p.PredictConditionalContributions(features, 0, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{0, 2, 0}, on)
+
+	off, err := p.PredictConditionalContributions(features, 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{0, 1, 0}, off)
+
+	tests := []struct {
+		name             string
+		conditionFeature int
+		condition        int
+		wantErr          string
+	}{
+		{
+			name:             "no condition",
+			conditionFeature: 0,
+			condition:        0,
+			wantErr:          "condition must be 1 (present) or -1 (absent), got 0",
+		},
+		{
+			name:             "invalid condition",
+			conditionFeature: 0,
+			condition:        2,
+			wantErr:          "condition must be 1 (present) or -1 (absent), got 2",
+		},
+		{
+			name:             "negative feature",
+			conditionFeature: -1,
+			condition:        1,
+			wantErr:          "condition feature -1 is out of range for 2 features",
+		},
+		{
+			name:             "feature out of range",
+			conditionFeature: 2,
+			condition:        1,
+			wantErr:          "condition feature 2 is out of range for 2 features",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := p.PredictConditionalContributions(
+				features,
+				test.conditionFeature,
+				test.condition,
+			)
+			require.EqualError(t, err, test.wantErr)
+		})
+	}
+
+	t.Run("rejects multiple groups", func(t *testing.T) {
+		p, err := NewPredictor("testdata/multiclass/model.json")
+		require.NoError(t, err)
+
+		_, err = p.PredictConditionalContributions(features, 0, 1)
+		require.ErrorContains(t, err, "3 output groups")
+	})
+}
+
+func TestPredictConditionalContributionsMatchInteractions(t *testing.T) {
+	// Interaction values are (on - off) / 2, where on and off are the
+	// contributions conditioned on a feature being present and absent.
+	// PredictInteractions is checked against XGBoost's contributions by
+	// TestPredictInteractionsRowsSumToContributions.
+	p, err := NewPredictor("testdata/roundtrip/model.json")
+	require.NoError(t, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
+	for row, features := range allFeatures[:20] {
+		interactions, err := p.PredictInteractions(features)
+		require.NoError(t, err)
+
+		for i := range p.numFeature {
+			on, err := p.PredictConditionalContributions(features, i, 1)
+			require.NoError(t, err)
+
+			off, err := p.PredictConditionalContributions(features, i, -1)
+			require.NoError(t, err)
+
+			assert.Zero(t, on[i], "row %d: feature %d", row, i)
+			assert.Zero(t, off[i], "row %d: feature %d", row, i)
+			for j := range p.numFeature {
+				if j == i {
+					continue
+				}
+				assert.InDelta(
+					t,
+					interactions[i][j],
+					(on[j]-off[j])/2,
+					1e-5,
+					"row %d: features %d and %d", row, i, j,
+				)
+			}
+		}
+	}
+}
+
+func TestPredictApproxContributions(t *testing.T) {
+	// See TestPredictInteractions for this model. With x = (1, 1), the path
+	// goes from the root (mean 1) to the x1 split (mean 2) to the leaf (4), so
+	// x0 gets 2 - 1 and x1 gets 4 - 2. The bias is the root's mean.
+	p, err := NewPredictor("testdata/interaction/model.json")
+	require.NoError(t, err)
+
+	contributions, err := p.PredictApproxContributions(
+		[]*float32{toPtr(1), toPtr(1)},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{1, 2, 1}, contributions)
+
+	t.Run("missing value follows the default direction", func(t *testing.T) {
+		// x0 is missing and default_left is 0, so it goes right.
+		contributions, err := p.PredictApproxContributions(
+			[]*float32{nil, toPtr(0)},
+		)
+		require.NoError(t, err)
+		assert.Equal(t, []float32{1, -2, 1}, contributions)
+	})
+
+	t.Run("rejects multiple groups", func(t *testing.T) {
+		p, err := NewPredictor("testdata/multiclass/model.json")
+		require.NoError(t, err)
+
+		_, err = p.PredictApproxContributions([]*float32{toPtr(1), toPtr(1)})
+		require.ErrorContains(t, err, "3 output groups")
+	})
+}
+
+func TestPredictApproxContributionsSumToMargin(t *testing.T) {
+	p, err := NewPredictor("testdata/roundtrip/model.json")
+	require.NoError(t, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
+	for row, features := range allFeatures {
+		contributions, err := p.PredictApproxContributions(features)
+		require.NoError(t, err)
+
+		var sum float32
+		for _, c := range contributions {
+			sum += c
+		}
+
+		margin, err := p.PredictMargin(features)
+		require.NoError(t, err)
+		assert.InDelta(t, margin[0], sum, 1e-5, "row %d", row)
+	}
+}
+
+func TestPredictContributionsRoundtrip(t *testing.T) {
+	// model.ubj is the same model converted to XGBoost's UBJSON format by
+	// testdata/json-to-ubj.py, and xgboost-model.ubj is the same model saved
+	// as UBJSON by XGBoost itself.
+	for _, modelFile := range []string{"model.json", "model.ubj", "xgboost-model.ubj"} {
+		t.Run(modelFile, func(t *testing.T) {
+			path := "testdata/roundtrip/" + modelFile
+			skipWithoutFixture(t, path, "testdata/roundtrip/generate-model.py")
+			testPredictContributionsRoundtrip(t, path)
+		})
+	}
+}
+
+func testPredictContributionsRoundtrip(t *testing.T, modelFile string) {
+	p, err := NewPredictor(modelFile)
+	require.NoError(t, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
 	allContribs, err := readContributionsCSV("testdata/roundtrip/contributions.csv")
 	require.NoError(t, err)
 
@@ -166,30 +730,171 @@ func TestPredictContributionsRoundtrip(t *testing.T) {
 		"feature and contribution row counts must match",
 	)
 
-	const tolerance = 1e-5
-
 	for row, features := range allFeatures {
 		got, err := p.PredictContributions(features)
 		require.NoError(t, err)
 
-		expected := allContribs[row]
+		// The last element is the bias, which includes the model's base_score.
+		require.Len(t, got, len(features)+1, "row %d: unexpected contribution length", row)
+		assertMatchesGolden(t, allContribs[row], got, 1e-5, fmt.Sprintf("row %d", row))
+	}
+}
 
-		// The last element is the bias. xgbshap does not yet add base_score
-		// to it, so it will differ from XGBoost's output. Skip it.
-		nFeatures := len(features)
-		require.Len(t, got, nFeatures+1, "row %d: unexpected contribution length", row)
-		require.Len(t, expected, nFeatures+1, "row %d: unexpected golden length", row)
+func TestPredictInteractionsRoundtrip(t *testing.T) {
+	const interactionsFile = "testdata/roundtrip/interactions.csv"
+	skipWithoutFixture(t, interactionsFile, "testdata/roundtrip/generate-model.py")
 
-		for col := range nFeatures {
-			absDelta := math.Abs(float64(got[col] - expected[col]))
-			relDelta := absDelta / math.Max(math.Abs(float64(expected[col])), 1.0)
+	p, err := NewPredictor("testdata/roundtrip/model.json")
+	require.NoError(t, err)
 
-			if relDelta > tolerance && absDelta > tolerance {
-				t.Errorf(
-					"row %d, feature %d: got %g, want %g (absDelta=%g, relDelta=%g)",
-					row, col, got[col], expected[col], absDelta, relDelta,
-				)
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
+	allInteractions, err := readContributionsCSV(interactionsFile)
+	require.NoError(t, err)
+	require.Len(t, allInteractions, len(allFeatures))
+
+	for row, features := range allFeatures {
+		got, err := p.PredictInteractions(features)
+		require.NoError(t, err)
+
+		var flat []float32
+		for _, r := range got {
+			flat = append(flat, r...)
+		}
+		assertMatchesGolden(t, allInteractions[row], flat, 1e-5, fmt.Sprintf("row %d", row))
+	}
+}
+
+func TestFillDerivedValues(t *testing.T) {
+	p, err := NewPredictor("testdata/interaction/model.json")
+	require.NoError(t, err)
+
+	require.Len(t, p.trees, 1)
+	assert.Equal(t, []float32{1, 0, 2, 0, 4}, p.trees[0].meanValues)
+	assert.Equal(t, 2, p.trees[0].maxDepth)
+	// Leaves have a split index of 0, which must not count as a split.
+	assert.Equal(t, []int{0, 1}, p.trees[0].splitFeatures)
+}
+
+func BenchmarkPredictContributions(b *testing.B) {
+	p, err := NewPredictor("testdata/small-model/model.json")
+	require.NoError(b, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/small-model/features.csv")
+	require.NoError(b, err)
+
+	for b.Loop() {
+		for _, features := range allFeatures {
+			_, err := p.PredictContributions(features)
+			require.NoError(b, err)
+		}
+	}
+}
+
+func TestPredictContributionsInto(t *testing.T) {
+	p, err := NewPredictor("testdata/roundtrip/model.json")
+	require.NoError(t, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
+	var scratch Scratch
+	dst := make([]float32, len(allFeatures[0])+1)
+	for row, features := range allFeatures {
+		want, err := p.PredictContributions(features)
+		require.NoError(t, err)
+
+		// dst is reused without clearing, so stale values must not leak into
+		// the result.
+		err = p.PredictContributionsInto(dst, features, &scratch)
+		require.NoError(t, err)
+		assert.Equal(t, want, dst, "row %d", row)
+	}
+
+	t.Run("nil scratch", func(t *testing.T) {
+		want, err := p.PredictContributions(allFeatures[0])
+		require.NoError(t, err)
+
+		err = p.PredictContributionsInto(dst, allFeatures[0], nil)
+		require.NoError(t, err)
+		assert.Equal(t, want, dst)
+	})
+
+	t.Run("wrong dst length", func(t *testing.T) {
+		err := p.PredictContributionsInto(
+			make([]float32, len(allFeatures[0])),
+			allFeatures[0],
+			&scratch,
+		)
+		require.ErrorContains(t, err, "dst has length")
+	})
+
+	t.Run("does not allocate", func(t *testing.T) {
+		allocs := testing.AllocsPerRun(10, func() {
+			for _, features := range allFeatures {
+				err := p.PredictContributionsInto(dst, features, &scratch)
+				if err != nil {
+					t.Fatal(err)
+				}
 			}
+		})
+		assert.Zero(t, allocs)
+	})
+}
+
+func BenchmarkPredictContributionsInto(b *testing.B) {
+	p, err := NewPredictor("testdata/small-model/model.json")
+	require.NoError(b, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/small-model/features.csv")
+	require.NoError(b, err)
+
+	var scratch Scratch
+	dst := make([]float32, len(allFeatures[0])+1)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, features := range allFeatures {
+			err := p.PredictContributionsInto(dst, features, &scratch)
+			require.NoError(b, err)
+		}
+	}
+}
+
+// skipWithoutFixture skips t if the fixture at path, which is generated by
+// running script with XGBoost or shap installed, has not been generated.
+func skipWithoutFixture(t *testing.T, path, script string) {
+	t.Helper()
+
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Skipf("%s does not exist; generate it with %s", path, script)
+	}
+	require.NoError(t, err)
+}
+
+// assertMatchesGolden checks that got matches the golden values in want to
+// within tolerance, either absolute or relative to the golden value.
+func assertMatchesGolden(
+	t *testing.T,
+	want,
+	got []float32,
+	tolerance float64,
+	msg string,
+) {
+	t.Helper()
+
+	require.Len(t, want, len(got), "%s: unexpected golden length", msg)
+	for col := range want {
+		absDelta := math.Abs(float64(got[col] - want[col]))
+		relDelta := absDelta / math.Max(math.Abs(float64(want[col])), 1.0)
+
+		if relDelta > tolerance && absDelta > tolerance {
+			t.Errorf(
+				"%s, column %d: got %g, want %g (absDelta=%g, relDelta=%g)",
+				msg, col, got[col], want[col], absDelta, relDelta,
+			)
 		}
 	}
 }
@@ -0,0 +1,289 @@
+package xgbshap
+
+import (
+	"fmt"
+	"math/bits"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterventionalExplainer(t *testing.T) {
+	// See TestPredictInteractions for this model, which outputs 4 when both
+	// features are at least 0.5 and 0 otherwise. Against the background row
+	// (0, 0), x = (1, 1) only scores when both features come from x, so the
+	// 4 is split evenly between them and the bias is f(0, 0) = 0.
+	p, err := NewPredictor("testdata/interaction/model.json")
+	require.NoError(t, err)
+
+	x := []*float32{toPtr(1), toPtr(1)}
+
+	e, err := NewInterventionalExplainer(p, [][]*float32{{toPtr(0), toPtr(0)}})
+	require.NoError(t, err)
+
+	contributions, err := e.PredictContributions(x)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{2, 2, 0}, contributions)
+
+	// Adding x itself to the background contributes nothing to the features
+	// and 4 to the bias, so averaging over the two rows halves the
+	// contributions and gives a bias of 2.
+	e, err = NewInterventionalExplainer(p, [][]*float32{
+		{toPtr(0), toPtr(0)},
+		{toPtr(1), toPtr(1)},
+	})
+	require.NoError(t, err)
+
+	contributions, err = e.PredictContributions(x)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{1, 1, 2}, contributions)
+
+	t.Run("per-call base margin", func(t *testing.T) {
+		contributions, err := e.PredictContributions(x, BaseMargin(10))
+		require.NoError(t, err)
+		assert.Equal(t, []float32{1, 1, 12}, contributions)
+	})
+}
+
+func TestInterventionalExplainerMatchesBruteForce(t *testing.T) {
+	roundtrip, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		model      string
+		rows       [][]*float32
+		background [][]*float32
+		opts       []PredictOption
+	}{
+		{
+			// This model has categorical features and missing values.
+			name:       "roundtrip",
+			model:      "testdata/roundtrip/model.json",
+			rows:       roundtrip[:5],
+			background: roundtrip[100:110],
+		},
+		{
+			name:       "roundtrip with iterations",
+			model:      "testdata/roundtrip/model.json",
+			rows:       roundtrip[:5],
+			background: roundtrip[100:110],
+			opts:       []PredictOption{Iterations(2, 5)},
+		},
+		{
+			name:  "DART",
+			model: "testdata/dart/model.json",
+			rows: [][]*float32{
+				{toPtr(-1)},
+				{toPtr(0.5)},
+				{nil},
+			},
+			background: [][]*float32{{toPtr(0)}, {toPtr(2)}, {nil}},
+		},
+		{
+			name:  "random forest",
+			model: "testdata/rf-regressor/model.json",
+			rows: [][]*float32{
+				{toPtr(0), toPtr(0)},
+				{toPtr(1), toPtr(-1)},
+				{nil, toPtr(3)},
+			},
+			background: [][]*float32{
+				{toPtr(-1), toPtr(2)},
+				{toPtr(0.5), nil},
+				{toPtr(2), toPtr(0)},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := NewPredictor(test.model)
+			require.NoError(t, err)
+
+			e, err := NewInterventionalExplainer(p, test.background)
+			require.NoError(t, err)
+
+			for row, x := range test.rows {
+				got, err := e.PredictContributions(x, test.opts...)
+				require.NoError(t, err)
+
+				want := bruteForceInterventional(t, p, x, test.background, test.opts)
+				require.Len(t, got, len(want))
+				for i := range want {
+					assert.InDelta(t, want[i], got[i], 1e-4, "row %d, column %d", row, i)
+				}
+
+				margin, err := p.PredictMargin(x, test.opts...)
+				require.NoError(t, err)
+
+				var sum float32
+				for _, c := range got {
+					sum += c
+				}
+				assert.InDelta(t, margin[0], sum, 1e-4, "row %d", row)
+			}
+		})
+	}
+}
+
+func TestInterventionalExplainerMatchesShap(t *testing.T) {
+	const dir = "testdata/interventional/"
+	skipWithoutFixture(t, dir+"contributions.csv", dir+"generate-model.py")
+
+	p, err := NewPredictor(dir + "model.json")
+	require.NoError(t, err)
+
+	background, err := readFeaturesCSV(dir + "background.csv")
+	require.NoError(t, err)
+
+	allFeatures, err := readFeaturesCSV(dir + "features.csv")
+	require.NoError(t, err)
+
+	allContribs, err := readContributionsCSV(dir + "contributions.csv")
+	require.NoError(t, err)
+	require.Len(t, allContribs, len(allFeatures))
+
+	e, err := NewInterventionalExplainer(p, background)
+	require.NoError(t, err)
+
+	for row, features := range allFeatures {
+		got, err := e.PredictContributions(features)
+		require.NoError(t, err)
+
+		// shap calculates in float64, so allow for float32 rounding of the
+		// averages over the background rows.
+		assertMatchesGolden(t, allContribs[row], got, 1e-4, fmt.Sprintf("row %d", row))
+	}
+}
+
+func TestNewInterventionalExplainerErrors(t *testing.T) {
+	p, err := NewPredictor("testdata/interaction/model.json")
+	require.NoError(t, err)
+
+	t.Run("empty background", func(t *testing.T) {
+		_, err := NewInterventionalExplainer(p, nil)
+		require.EqualError(t, err, "background dataset has no rows")
+	})
+
+	t.Run("wrong feature count", func(t *testing.T) {
+		_, err := NewInterventionalExplainer(p, [][]*float32{
+			{toPtr(0), toPtr(0)},
+			{toPtr(0)},
+		})
+		var countErr *FeatureCountError
+		require.ErrorAs(t, err, &countErr)
+		require.EqualError(
+			t,
+			err,
+			"background row 1: got 1 features but the model expects 2",
+		)
+	})
+
+	t.Run("invalid category", func(t *testing.T) {
+		p, err := NewPredictor(
+			"testdata/categorical/model.json",
+			OnInvalidCategory(InvalidCategoryError),
+		)
+		require.NoError(t, err)
+
+		_, err = NewInterventionalExplainer(p, [][]*float32{{toPtr(-1)}})
+		var categoryErr *CategoryError
+		require.ErrorAs(t, err, &categoryErr)
+	})
+
+	t.Run("multiple groups", func(t *testing.T) {
+		p, err := NewPredictor("testdata/multiclass/model.json")
+		require.NoError(t, err)
+
+		_, err = NewInterventionalExplainer(p, [][]*float32{{toPtr(1), toPtr(1)}})
+		require.ErrorContains(t, err, "3 output groups")
+	})
+}
+
+func TestInterventionalExplainerCopiesBackground(t *testing.T) {
+	p, err := NewPredictor("testdata/interaction/model.json")
+	require.NoError(t, err)
+
+	background := [][]*float32{{toPtr(0), toPtr(0)}}
+	e, err := NewInterventionalExplainer(p, background)
+	require.NoError(t, err)
+
+	*background[0][0] = 1
+	*background[0][1] = 1
+
+	contributions, err := e.PredictContributions([]*float32{toPtr(1), toPtr(1)})
+	require.NoError(t, err)
+	assert.Equal(t, []float32{2, 2, 0}, contributions)
+}
+
+func TestShapleyWeight(t *testing.T) {
+	tests := []struct {
+		a, b int
+		want float64
+	}{
+		{a: 0, b: 0, want: 1},
+		{a: 1, b: 0, want: 1.0 / 2},
+		{a: 0, b: 1, want: 1.0 / 2},
+		{a: 1, b: 1, want: 1.0 / 6},
+		{a: 2, b: 1, want: 2.0 / 24},
+		{a: 3, b: 2, want: 6.0 * 2 / 720},
+	}
+
+	for _, test := range tests {
+		assert.InDelta(
+			t,
+			test.want,
+			shapleyWeight(test.a, test.b),
+			1e-12,
+			"a=%d, b=%d", test.a, test.b,
+		)
+	}
+}
+
+// bruteForceInterventional calculates the interventional SHAP values of x by
+// enumerating every coalition of features, evaluating the model on rows that
+// take the coalition's values from x and the rest from each background row.
+func bruteForceInterventional(
+	t *testing.T,
+	p *Predictor,
+	x []*float32,
+	background [][]*float32,
+	opts []PredictOption,
+) []float64 {
+	t.Helper()
+
+	n := len(x)
+	values := make([]float64, 1<<n)
+	hybrid := make([]*float32, n)
+	for coalition := range values {
+		for _, z := range background {
+			for i := range n {
+				if coalition&(1<<i) != 0 {
+					hybrid[i] = x[i]
+				} else {
+					hybrid[i] = z[i]
+				}
+			}
+			margin, err := p.PredictMargin(hybrid, opts...)
+			require.NoError(t, err)
+			values[coalition] += float64(margin[0]) / float64(len(background))
+		}
+	}
+
+	phi := make([]float64, n+1)
+	for coalition, value := range values {
+		size := bits.OnesCount(uint(coalition))
+		for i := range n {
+			if coalition&(1<<i) != 0 {
+				continue
+			}
+			phi[i] += shapleyWeight(size, n-size-1) *
+				(values[coalition|1<<i] - value)
+		}
+	}
+	phi[n] = values[0]
+
+	return phi
+}
@@ -0,0 +1,149 @@
+package xgbshap
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// FeatureContribution is the contribution of a single named feature.
+type FeatureContribution struct {
+	// Name is the feature's name in the model.
+	Name string
+	// Contribution is the feature's contribution to the prediction.
+	Contribution float32
+}
+
+// NamedContributions holds the contributions calculated by
+// PredictContributionsMap.
+type NamedContributions struct {
+	// Features holds the contribution of every feature of the model, in the
+	// model's column order.
+	Features []FeatureContribution
+	// Bias is the contribution that is not attributed to any feature.
+	Bias float32
+}
+
+// FeatureNames returns the model's feature names in column order, or nil if
+// the model was trained without feature names.
+func (p *Predictor) FeatureNames() []string {
+	if len(p.featureNames) == 0 {
+		return nil
+	}
+	return slices.Clone(p.featureNames)
+}
+
+// PredictContributionsMap calculates the contributions of features given by
+// name, for models that record their feature names. Names that are not
+// features of the model and features that are absent from the map are
+// handled according to the OnUnknownFeature and OnMissingFeature options.
+//
+// Like PredictContributions, it returns an error for models with more than
+// one output group.
+func (p *Predictor) PredictContributionsMap(
+	features map[string]float32,
+	opts ...PredictOption,
+) (*NamedContributions, error) {
+	row, err := p.featureRow(features)
+	if err != nil {
+		return nil, err
+	}
+
+	contributions, err := p.PredictContributions(row, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	named := &NamedContributions{
+		Features: make([]FeatureContribution, len(p.featureNames)),
+		Bias:     contributions[len(p.featureNames)],
+	}
+	for i, name := range p.featureNames {
+		named.Features[i] = FeatureContribution{
+			Name:         name,
+			Contribution: contributions[i],
+		}
+	}
+
+	return named, nil
+}
+
+// featureRow converts features given by name into the column order of the
+// model.
+func (p *Predictor) featureRow(features map[string]float32) ([]*float32, error) {
+	if len(p.featureNames) == 0 {
+		return nil, errors.New("model has no feature names")
+	}
+
+	row := make([]*float32, len(p.featureNames))
+	values := make([]float32, len(p.featureNames))
+
+	var unknown []string
+	for name, value := range features {
+		i, ok := p.featureIndexes[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		values[i] = value
+		row[i] = &values[i]
+	}
+
+	if len(unknown) > 0 && p.unknownFeatures == UnknownFeatureError {
+		slices.Sort(unknown)
+		return nil, fmt.Errorf(
+			"unknown features: %s",
+			strings.Join(unknown, ", "),
+		)
+	}
+
+	if p.missingFeatures == MissingFeatureError {
+		var missing []string
+		for i, name := range p.featureNames {
+			if row[i] == nil {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf(
+				"missing features: %s",
+				strings.Join(missing, ", "),
+			)
+		}
+	}
+
+	return row, nil
+}
+
+// resolveFeatureNames checks the model's feature names against the number of
+// features and returns the column of each name. It returns nil if the model
+// has no feature names.
+func resolveFeatureNames(names []string, numFeature int) (map[string]int, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	if len(names) != numFeature {
+		return nil, fmt.Errorf(
+			"model has %d feature names but %d features",
+			len(names),
+			numFeature,
+		)
+	}
+
+	indexes := make(map[string]int, len(names))
+	for i, name := range names {
+		if j, ok := indexes[name]; ok {
+			return nil, fmt.Errorf(
+				"feature name %q is used by features %d and %d",
+				name,
+				j,
+				i,
+			)
+		}
+		indexes[name] = i
+	}
+
+	return indexes, nil
+}
@@ -0,0 +1,167 @@
+package xgbshap
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureNames(t *testing.T) {
+	p, err := NewPredictor("testdata/roundtrip/model.json")
+	require.NoError(t, err)
+
+	names := p.FeatureNames()
+	assert.Equal(
+		t,
+		[]string{"num0", "cat0", "num1", "cat1", "num2", "cat2"},
+		names,
+	)
+
+	// Modifying the returned slice must not affect the Predictor.
+	names[0] = "changed"
+	assert.Equal(t, "num0", p.FeatureNames()[0])
+
+	t.Run("model without names", func(t *testing.T) {
+		p, err := NewPredictor("testdata/interaction/model.json")
+		require.NoError(t, err)
+		assert.Nil(t, p.FeatureNames())
+	})
+}
+
+func TestPredictContributionsMap(t *testing.T) {
+	p, err := NewPredictor("testdata/roundtrip/model.json")
+	require.NoError(t, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
+	for row, features := range allFeatures[:20] {
+		named := map[string]float32{}
+		for i, name := range p.FeatureNames() {
+			if features[i] != nil {
+				named[name] = *features[i]
+			}
+		}
+
+		got, err := p.PredictContributionsMap(named)
+		require.NoError(t, err)
+
+		want, err := p.PredictContributions(features)
+		require.NoError(t, err)
+
+		require.Len(t, got.Features, len(want)-1)
+		for i, name := range p.FeatureNames() {
+			assert.Equal(t, name, got.Features[i].Name)
+			assert.InDelta(t, want[i], got.Features[i].Contribution, 1e-6, "row %d", row)
+		}
+		assert.InDelta(t, want[len(want)-1], got.Bias, 1e-6, "row %d", row)
+	}
+}
+
+func TestPredictContributionsMapPolicies(t *testing.T) {
+	features := map[string]float32{
+		"num0": 1,
+		"cat0": 2,
+		"num1": 3,
+		"cat1": 0,
+		"num2": 5,
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		p, err := NewPredictor("testdata/roundtrip/model.json")
+		require.NoError(t, err)
+
+		// cat2 is missing, which is allowed by default.
+		_, err = p.PredictContributionsMap(features)
+		require.NoError(t, err)
+
+		withUnknown := map[string]float32{"bogus": 1, "also_bogus": 2}
+		_, err = p.PredictContributionsMap(withUnknown)
+		require.EqualError(t, err, "unknown features: also_bogus, bogus")
+	})
+
+	t.Run("missing treated as missing", func(t *testing.T) {
+		p, err := NewPredictor("testdata/roundtrip/model.json")
+		require.NoError(t, err)
+
+		got, err := p.PredictContributionsMap(features)
+		require.NoError(t, err)
+
+		want, err := p.PredictContributions([]*float32{
+			toPtr(1), toPtr(2), toPtr(3), toPtr(0), toPtr(5), nil,
+		})
+		require.NoError(t, err)
+
+		for i := range got.Features {
+			assert.InDelta(t, want[i], got.Features[i].Contribution, 1e-6)
+		}
+	})
+
+	t.Run("missing is an error", func(t *testing.T) {
+		p, err := NewPredictor(
+			"testdata/roundtrip/model.json",
+			OnMissingFeature(MissingFeatureError),
+		)
+		require.NoError(t, err)
+
+		_, err = p.PredictContributionsMap(features)
+		require.EqualError(t, err, "missing features: cat2")
+	})
+
+	t.Run("unknown is ignored", func(t *testing.T) {
+		p, err := NewPredictor(
+			"testdata/roundtrip/model.json",
+			OnUnknownFeature(UnknownFeatureIgnore),
+		)
+		require.NoError(t, err)
+
+		withUnknown := map[string]float32{"bogus": 1}
+		maps.Copy(withUnknown, features)
+
+		got, err := p.PredictContributionsMap(withUnknown)
+		require.NoError(t, err)
+
+		want, err := p.PredictContributionsMap(features)
+		require.NoError(t, err)
+
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("model without names", func(t *testing.T) {
+		p, err := NewPredictor("testdata/interaction/model.json")
+		require.NoError(t, err)
+
+		_, err = p.PredictContributionsMap(features)
+		require.EqualError(t, err, "model has no feature names")
+	})
+}
+
+func TestResolveFeatureNames(t *testing.T) {
+	t.Run("no names", func(t *testing.T) {
+		indexes, err := resolveFeatureNames(nil, 3)
+		require.NoError(t, err)
+		assert.Nil(t, indexes)
+	})
+
+	t.Run("names are indexed", func(t *testing.T) {
+		indexes, err := resolveFeatureNames([]string{"a", "b"}, 2)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"a": 0, "b": 1}, indexes)
+	})
+
+	t.Run("wrong number of names", func(t *testing.T) {
+		_, err := resolveFeatureNames([]string{"a", "b"}, 3)
+		require.EqualError(t, err, "model has 2 feature names but 3 features")
+	})
+
+	t.Run("duplicate names", func(t *testing.T) {
+		_, err := resolveFeatureNames([]string{"a", "b", "a"}, 3)
+		require.EqualError(
+			t,
+			err,
+			`feature name "a" is used by features 0 and 2`,
+		)
+	})
+}
@@ -0,0 +1,157 @@
+package xgbshap
+
+// Much of this code is ported from the xgboost C++ code.
+//
+// Copyright by XGBoost Contributors 2017-2023
+//
+// xgboost's code is Apache 2.0 licensed.
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseBaseScore parses learner_model_param.base_score into one value per
+// output group. A single value applies to every group. An empty string, as in
+// models that do not record base_score, yields nil. Non-finite values are
+// rejected, as they would make every bias and margin NaN or infinite.
+func parseBaseScore(s string, numGroup int) ([]float32, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	}
+
+	parts := strings.Split(s, ",")
+	scores := make([]float32, len(parts))
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		f, err := strconv.ParseFloat(part, 32)
+		if err != nil {
+			return nil, fmt.Errorf("parsing base_score: %w", err)
+		}
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("base_score %q is not finite", part)
+		}
+		scores[i] = float32(f)
+	}
+
+	switch len(scores) {
+	case numGroup:
+		return scores, nil
+	case 1:
+		broadcast := make([]float32, numGroup)
+		for i := range broadcast {
+			broadcast[i] = scores[0]
+		}
+		return broadcast, nil
+	default:
+		return nil, fmt.Errorf(
+			"base_score has %d values; the model has %d output groups",
+			len(scores),
+			numGroup,
+		)
+	}
+}
+
+// probToMargin transforms a base_score from the objective's output space into
+// margin space, the space that trees' leaf values are summed in.
+//
+// This is equivalent to ProbToMargin() in xgboost's objectives.
+func probToMargin(objective string, baseScore float32) (float32, error) {
+	switch objective {
+	case "binary:logistic", "binary:logitraw", "reg:logistic":
+		if baseScore <= 0 || baseScore >= 1 {
+			return 0, fmt.Errorf(
+				"base_score must be in (0, 1) for %s, got %v",
+				objective,
+				baseScore,
+			)
+		}
+		// xgboost does this arithmetic in single precision.
+		return float32(-math.Log(float64(1/baseScore - 1))), nil
+	case "count:poisson", "reg:gamma", "reg:tweedie", "survival:aft",
+		"survival:cox":
+		if baseScore <= 0 {
+			return 0, fmt.Errorf(
+				"base_score must be positive for %s, got %v",
+				objective,
+				baseScore,
+			)
+		}
+		return float32(math.Log(float64(baseScore))), nil
+	default:
+		return baseScore, nil
+	}
+}
+
+// predTransform transforms margins, one per output group, into the
+// objective's output space, e.g., probabilities for binary:logistic. It
+// transforms margin in place where it can and returns the result.
+//
+// This is equivalent to PredTransform() in xgboost's objectives.
+func predTransform(objective string, margin []float32) []float32 {
+	switch objective {
+	case "binary:logistic", "reg:logistic":
+		for i, m := range margin {
+			margin[i] = sigmoid(m)
+		}
+	case "multi:softprob":
+		softmax(margin)
+	case "multi:softmax":
+		// multi:softmax predicts the index of the most likely class.
+		best := 0
+		for i, m := range margin {
+			if m > margin[best] {
+				best = i
+			}
+		}
+		return []float32{float32(best)}
+	case "count:poisson", "reg:gamma", "reg:tweedie", "survival:aft",
+		"survival:cox":
+		for i, m := range margin {
+			margin[i] = float32(math.Exp(float64(m)))
+		}
+	case "binary:hinge":
+		for i, m := range margin {
+			if m > 0 {
+				margin[i] = 1
+			} else {
+				margin[i] = 0
+			}
+		}
+	}
+	return margin
+}
+
+// This is equivalent to Sigmoid() in xgboost (math.h).
+func sigmoid(x float32) float32 {
+	return 1 / (1 + float32(math.Exp(float64(-x))))
+}
+
+// This is equivalent to Softmax() in xgboost (math.h).
+func softmax(x []float32) {
+	if len(x) == 0 {
+		return
+	}
+
+	wmax := x[0]
+	for _, v := range x[1:] {
+		wmax = max(wmax, v)
+	}
+
+	var wsum float32
+	for i, v := range x {
+		x[i] = float32(math.Exp(float64(v - wmax)))
+		wsum += x[i]
+	}
+
+	for i := range x {
+		x[i] /= wsum
+	}
+}
@@ -0,0 +1,129 @@
+package xgbshap
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBaseScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		numGroup int
+		want     []float32
+	}{
+		{name: "absent", in: "", numGroup: 1, want: nil},
+		{name: "scientific notation", in: "5E-1", numGroup: 1, want: []float32{0.5}},
+		{name: "vector form", in: "[5.190476E-1]", numGroup: 1, want: []float32{0.5190476}},
+		{name: "scalar broadcast", in: "5E-1", numGroup: 3, want: []float32{0.5, 0.5, 0.5}},
+		{
+			name:     "one value per group",
+			in:       "[1E-1, 2E-1,3E-1]",
+			numGroup: 3,
+			want:     []float32{0.1, 0.2, 0.3},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseBaseScore(test.in, test.numGroup)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	t.Run("non-numeric", func(t *testing.T) {
+		_, err := parseBaseScore("bogus", 1)
+		require.ErrorContains(t, err, "base_score")
+	})
+
+	t.Run("non-finite", func(t *testing.T) {
+		for _, in := range []string{"NaN", "Inf", "-Inf", "[5E-1,NaN]"} {
+			_, err := parseBaseScore(in, 2)
+			require.ErrorContains(t, err, "is not finite", in)
+		}
+	})
+
+	t.Run("wrong number of values", func(t *testing.T) {
+		_, err := parseBaseScore("[1E-1,2E-1]", 3)
+		require.ErrorContains(t, err, "2 values")
+	})
+}
+
+func TestProbToMargin(t *testing.T) {
+	tests := []struct {
+		objective string
+		in        float32
+		want      float64
+	}{
+		{objective: "binary:logistic", in: 0.5, want: 0},
+		{objective: "binary:logistic", in: 0.75, want: math.Log(3)},
+		{objective: "reg:logistic", in: 0.25, want: -math.Log(3)},
+		{objective: "binary:logitraw", in: 0.5, want: 0},
+		{objective: "count:poisson", in: 2, want: math.Log(2)},
+		{objective: "reg:gamma", in: 1, want: 0},
+		{objective: "reg:tweedie", in: 0.5, want: math.Log(0.5)},
+		{objective: "reg:squarederror", in: 0.5, want: 0.5},
+		{objective: "multi:softprob", in: 0.5, want: 0.5},
+		{objective: "", in: 0.5, want: 0.5},
+	}
+	for _, test := range tests {
+		t.Run(test.objective, func(t *testing.T) {
+			got, err := probToMargin(test.objective, test.in)
+			require.NoError(t, err)
+			assert.InDelta(t, test.want, got, 1e-6)
+		})
+	}
+
+	t.Run("logistic base_score out of range", func(t *testing.T) {
+		_, err := probToMargin("binary:logistic", 1)
+		require.Error(t, err)
+	})
+
+	t.Run("log link base_score not positive", func(t *testing.T) {
+		_, err := probToMargin("count:poisson", 0)
+		require.Error(t, err)
+	})
+}
+
+func TestPredTransform(t *testing.T) {
+	tests := []struct {
+		objective string
+		in        []float32
+		want      []float32
+	}{
+		{objective: "binary:logistic", in: []float32{0}, want: []float32{0.5}},
+		{
+			objective: "reg:logistic",
+			in:        []float32{float32(math.Log(3))},
+			want:      []float32{0.75},
+		},
+		{objective: "binary:logitraw", in: []float32{-2}, want: []float32{-2}},
+		{
+			objective: "multi:softprob",
+			in:        []float32{0, float32(math.Log(3))},
+			want:      []float32{0.25, 0.75},
+		},
+		{objective: "multi:softmax", in: []float32{0.1, 2, -1}, want: []float32{1}},
+		{
+			objective: "count:poisson",
+			in:        []float32{float32(math.Log(2))},
+			want:      []float32{2},
+		},
+		{objective: "reg:gamma", in: []float32{0}, want: []float32{1}},
+		{objective: "reg:tweedie", in: []float32{0}, want: []float32{1}},
+		{objective: "binary:hinge", in: []float32{0.5, -0.5}, want: []float32{1, 0}},
+		{objective: "reg:squarederror", in: []float32{1.5}, want: []float32{1.5}},
+	}
+	for _, test := range tests {
+		t.Run(test.objective, func(t *testing.T) {
+			got := predTransform(test.objective, test.in)
+			require.Len(t, got, len(test.want))
+			for i := range got {
+				assert.InDelta(t, test.want[i], got[i], 1e-6)
+			}
+		})
+	}
+}
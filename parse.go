@@ -6,23 +6,37 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"os"
-	"path/filepath"
 	"strconv"
 )
 
 // XXX Some of this code is similar to parse.go in xgb2code
 // (https://github.com/maxmind/xgb2code).
 
-// XGBModel corresponds to an XGBoost JSON model.
+// XGBModel corresponds to an XGBoost model. Models may be saved as JSON or as
+// UBJSON, XGBoost's default format since 2.0; both decode into this structure.
 type XGBModel struct {
 	Learner Learner `json:"learner"`
 }
 
 // Learner is the top level part of an XGBoost model.
 type Learner struct {
-	Attributes      Attributes      `json:"attributes"`
-	GradientBooster GradientBooster `json:"gradient_booster"`
+	Attributes Attributes `json:"attributes"`
+	// FeatureNames holds the name of each feature in column order. It is
+	// empty if the model was trained without feature names.
+	FeatureNames []string `json:"feature_names"`
+	// FeatureTypes holds the type of each feature in column order: "c" for
+	// categorical features and "float", "int", "i", or "q" for numeric ones.
+	// It is empty if the model was trained without feature types.
+	FeatureTypes      []string          `json:"feature_types"`
+	GradientBooster   GradientBooster   `json:"gradient_booster"`
+	LearnerModelParam LearnerModelParam `json:"learner_model_param"`
+	Objective         Objective         `json:"objective"`
+}
+
+// Objective holds the learning objective of an XGBoost model.
+type Objective struct {
+	// Name is the objective's name, e.g., "binary:logistic".
+	Name string `json:"name"`
 }
 
 // Attributes holds attributes from an XGBoost model.
@@ -31,14 +45,50 @@ type Attributes struct {
 	BestIteration  json.Number `json:"best_iteration"`
 }
 
+// LearnerModelParam holds model-wide parameters of an XGBoost model.
+type LearnerModelParam struct {
+	// BaseScore is the global bias in the objective's output space, e.g., a
+	// probability for binary:logistic. XGBoost writes it as a float string
+	// such as "5E-1" or, since 2.1, as a vector such as "[5E-1]".
+	BaseScore string `json:"base_score"`
+	// NumClass is the number of classes for multi-class objectives and 0
+	// otherwise.
+	NumClass json.Number `json:"num_class"`
+	// NumFeature is the number of features the model was trained with.
+	NumFeature json.Number `json:"num_feature"`
+	// NumTarget is the number of targets. It is absent in models saved by
+	// XGBoost versions before 2.0.
+	NumTarget json.Number `json:"num_target"`
+}
+
 // GradientBooster holds the XGBoost model.
 type GradientBooster struct {
-	Model Model `json:"model"`
+	// Name is the booster's name: "gbtree" or "dart". It may be empty in
+	// older models, which are treated as gbtree.
+	Name  string `json:"name"`
+	Model Model  `json:"model"`
+	// GBTree holds the trees of a DART booster, which XGBoost saves as a
+	// nested gbtree booster. parseModel copies its Model into the DART
+	// booster's Model so that the trees are found in the same place for both
+	// kinds of booster.
+	GBTree *GradientBooster `json:"gbtree"`
+	// WeightDrop holds the weight of each tree of a DART booster.
+	WeightDrop []float32 `json:"weight_drop"`
 }
 
 // Model is the XGBoost model.
 type Model struct {
-	Trees []XGBTree `json:"trees"`
+	GBTreeModelParam GBTreeModelParam `json:"gbtree_model_param"`
+	// TreeInfo holds the output group (class) of each tree.
+	TreeInfo []int     `json:"tree_info"`
+	Trees    []XGBTree `json:"trees"`
+}
+
+// GBTreeModelParam holds parameters of the trees of an XGBoost model.
+type GBTreeModelParam struct {
+	// NumParallelTree is the number of trees trained for each output group
+	// in each boosting round. It is greater than 1 for random forests.
+	NumParallelTree json.Number `json:"num_parallel_tree"`
 }
 
 // XGBTree is one tree in an XGBoost model as decoded from JSON.
@@ -68,6 +118,13 @@ type XGBTree struct {
 // TreeParam holds tree parameters.
 type TreeParam struct {
 	NumNodes json.Number `json:"num_nodes"`
+	// SizeLeafVector is the number of values in each leaf. It is 0 or 1 for
+	// the scalar-leaf trees this package supports. Trees trained with
+	// multi_strategy="multi_output_tree" have one value per target.
+	SizeLeafVector json.Number `json:"size_leaf_vector"`
+	// NumDeleted is the number of nodes that pruning removed from the tree.
+	// XGBoost keeps them in the per-node arrays as unreachable leaves.
+	NumDeleted json.Number `json:"num_deleted"`
 }
 
 // xgbFloat is a float32 decoded from XGBoost's JSON, where a number may appear
@@ -105,6 +162,16 @@ func (s *xgbFloat) UnmarshalJSON(b []byte) error {
 type Tree struct {
 	Nodes    []Node // Index 0 is the root.
 	NumNodes int
+
+	// meanValues holds each node's mean value, as computed by
+	// fillNodeMeanValues, maxDepth holds the root's MaxDepth(), and
+	// splitFeatures holds the distinct features the tree splits on, which
+	// are the only features it gives contributions to. They depend only on
+	// the tree, so they are computed once by fillDerivedValues rather than
+	// on every prediction.
+	meanValues    []float32
+	maxDepth      int
+	splitFeatures []int
 }
 
 // Node is a node in the Tree.
@@ -248,6 +315,46 @@ func checkSplitCondition(id int, sc float32, categorical, isLeaf bool) error {
 	return nil
 }
 
+// checkScalarLeaves returns an error for a tree whose leaves hold a vector of
+// values, one per target, rather than a single value. XGBoost trains such
+// trees for multi-target models with multi_strategy="multi_output_tree".
+func checkScalarLeaves(param TreeParam) error {
+	if param.SizeLeafVector == "" {
+		return nil
+	}
+	size, err := param.SizeLeafVector.Int64()
+	if err != nil {
+		return fmt.Errorf("parsing size_leaf_vector: %w", err)
+	}
+	if size < 0 {
+		return fmt.Errorf("invalid size_leaf_vector: %d", size)
+	}
+	if size > 1 {
+		return fmt.Errorf(
+			"tree has %d values per leaf; multi-target trees with vector "+
+				"leaves are not supported",
+			size,
+		)
+	}
+	return nil
+}
+
+// checkSumHessian validates a node's sum_hessian value. TreeSHAP weights each
+// child by its share of its parent's sum of hessians, so the values must be
+// finite and non-negative. A zero is allowed for any node; see
+// childFractions for how a decision node with a zero sum is handled.
+func checkSumHessian(id int, h float32) error {
+	if math.IsInf(float64(h), 0) || math.IsNaN(float64(h)) || h < 0 {
+		return fmt.Errorf(
+			"node %d has invalid sum of hessians (%v); it must be finite and "+
+				"non-negative",
+			id,
+			h,
+		)
+	}
+	return nil
+}
+
 // categorySets maps each categorical node's ID to the category values that
 // route to its right child, decoding XGBoost's flattened categories/segments/
 // sizes representation. It returns an empty map for models trained without
@@ -359,24 +466,33 @@ func presence(present bool) string {
 }
 
 func parseModel(
-	file string,
+	buf []byte,
 ) (*XGBModel, []*Tree, error) {
-	buf, err := os.ReadFile(filepath.Clean(file))
-	if err != nil {
-		return nil, nil, fmt.Errorf("reading file: %w", err)
+	if isUBJSON(buf) {
+		var err error
+		buf, err = ubjsonToJSON(buf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding UBJSON: %w", err)
+		}
+	} else {
+		buf = sanitizeNonFiniteNumbers(buf)
 	}
 
 	var xm XGBModel
-	if err := json.Unmarshal(sanitizeNonFiniteNumbers(buf), &xm); err != nil {
+	if err := json.Unmarshal(buf, &xm); err != nil {
 		return nil, nil, fmt.Errorf("unmarshaling: %w", err)
 	}
 
+	if err := resolveBooster(&xm.Learner.GradientBooster); err != nil {
+		return nil, nil, err
+	}
+
 	var trees []*Tree
 	//nolint:gocritic // Copies inefficiently, but should only be done once.
-	for _, t := range xm.Learner.GradientBooster.Model.Trees {
+	for i, t := range xm.Learner.GradientBooster.Model.Trees {
 		tree, err := parseTree(t)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, fmt.Errorf("tree %d: %w", i, err)
 		}
 
 		trees = append(trees, tree)
@@ -385,6 +501,202 @@ func parseModel(
 	return &xm, trees, nil
 }
 
+// resolveBooster checks the booster's type and, for DART boosters, moves the
+// nested gbtree model into place and checks the tree weights. Only DART
+// boosters may have tree weights.
+func resolveBooster(gb *GradientBooster) error {
+	switch gb.Name {
+	case "", "gbtree":
+		if gb.WeightDrop != nil {
+			return errors.New("weight_drop is only supported for dart boosters")
+		}
+		return nil
+	case "dart":
+	default:
+		return fmt.Errorf("unsupported booster %q", gb.Name)
+	}
+
+	if gb.GBTree == nil {
+		return errors.New("dart booster has no gbtree model")
+	}
+	gb.Model = gb.GBTree.Model
+
+	if len(gb.WeightDrop) != len(gb.Model.Trees) {
+		return fmt.Errorf(
+			"weight_drop has %d entries but the model has %d trees",
+			len(gb.WeightDrop),
+			len(gb.Model.Trees),
+		)
+	}
+
+	return nil
+}
+
+// checkArrayLengths checks that every per-node array has one entry per node,
+// so that parseTree can index them by node ID. The categorical arrays are
+// checked by categorySets.
+func checkArrayLengths(xt XGBTree, numNodes int64) error {
+	if numNodes < 1 {
+		return fmt.Errorf("invalid num_nodes %d", numNodes)
+	}
+
+	for _, a := range []struct {
+		name   string
+		length int
+	}{
+		{"base_weights", len(xt.BaseWeights)},
+		{"default_left", len(xt.DefaultLeft)},
+		{"left_children", len(xt.LeftChildren)},
+		{"right_children", len(xt.RightChildren)},
+		{"split_conditions", len(xt.SplitConditions)},
+		{"split_indices", len(xt.SplitIndices)},
+		{"sum_hessian", len(xt.SumHessian)},
+	} {
+		if int64(a.length) != numNodes {
+			return fmt.Errorf(
+				"%s has %d entries but num_nodes is %d",
+				a.name,
+				a.length,
+				numNodes,
+			)
+		}
+	}
+
+	return nil
+}
+
+// maxTreeDepth is the greatest tree depth that parseTree accepts. TreeSHAP
+// needs memory quadratic in a tree's depth, so an absurdly deep tree in a
+// corrupt model would otherwise exhaust memory when calculating
+// contributions. At this depth, that memory is about 12 MiB, while trained
+// trees are rarely deeper than a few dozen levels.
+const maxTreeDepth = 1024
+
+// parseNumDeleted returns the tree's num_deleted, which is 0 when the model
+// does not record it. The root is never deleted, so at most numNodes-1 nodes
+// may be.
+func parseNumDeleted(param TreeParam, numNodes int64) (int, error) {
+	if param.NumDeleted == "" {
+		return 0, nil
+	}
+	n, err := param.NumDeleted.Int64()
+	if err != nil {
+		return 0, fmt.Errorf("parsing num_deleted: %w", err)
+	}
+	if n < 0 || n >= numNodes {
+		return 0, fmt.Errorf(
+			"invalid num_deleted %d for %d nodes",
+			n,
+			numNodes,
+		)
+	}
+	return int(n), nil
+}
+
+// checkStructure checks that the child arrays describe a binary tree rooted
+// at node 0: every node is a leaf (both children -1) or has two distinct
+// children in range, every node other than the root is the child of exactly
+// one node, and every node is reachable from the root. This rules out cycles,
+// which would otherwise cause unbounded recursion when walking the tree. The
+// exception is nodes deleted by pruning, which XGBoost leaves in place as
+// unreachable leaves, so up to numDeleted unreachable leaves are allowed. It
+// also checks that the tree is no deeper than maxTreeDepth.
+func checkStructure(leftChildren, rightChildren []int, numDeleted int) error {
+	numNodes := len(leftChildren)
+
+	parents := make([]int, numNodes)
+	for i := range parents {
+		parents[i] = -1
+	}
+
+	for i := range numNodes {
+		left := leftChildren[i]
+		right := rightChildren[i]
+
+		if left == -1 && right == -1 {
+			continue
+		}
+		if left == -1 || right == -1 {
+			return fmt.Errorf(
+				"node %d has only one child (left %d, right %d)",
+				i,
+				left,
+				right,
+			)
+		}
+		if left == right {
+			return fmt.Errorf("node %d has the same left and right child %d", i, left)
+		}
+
+		for _, child := range []int{left, right} {
+			// The root cannot be a child, so valid child IDs start at 1.
+			if child < 1 || child >= numNodes {
+				return fmt.Errorf(
+					"node %d has child %d, which is out of range for %d nodes",
+					i,
+					child,
+					numNodes,
+				)
+			}
+			if parents[child] != -1 {
+				return fmt.Errorf(
+					"node %d is a child of both node %d and node %d",
+					child,
+					parents[child],
+					i,
+				)
+			}
+			parents[child] = i
+		}
+	}
+
+	// Every node has at most one parent and the root has none, so walking
+	// from the root visits each reachable node once. Any node it does not
+	// reach is disconnected from the tree, possibly as part of a cycle, unless
+	// it is a deleted leaf.
+	reachable := make([]bool, numNodes)
+	reachable[0] = true
+	depths := make([]int, numNodes)
+	stack := []int{0}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if leftChildren[i] == -1 {
+			continue
+		}
+		if depths[i] == maxTreeDepth {
+			return fmt.Errorf(
+				"tree is deeper than the maximum depth of %d",
+				maxTreeDepth,
+			)
+		}
+		for _, child := range []int{leftChildren[i], rightChildren[i]} {
+			reachable[child] = true
+			depths[child] = depths[i] + 1
+			stack = append(stack, child)
+		}
+	}
+	unreachableLeaves := 0
+	for i, ok := range reachable {
+		if ok {
+			continue
+		}
+		if leftChildren[i] != -1 {
+			return fmt.Errorf("node %d is not reachable from the root", i)
+		}
+		unreachableLeaves++
+		if unreachableLeaves > numDeleted {
+			return fmt.Errorf(
+				"node %d is not reachable from the root and num_deleted is %d",
+				i,
+				numDeleted,
+			)
+		}
+	}
+
+	return nil
+}
+
 func parseTree(
 	xt XGBTree,
 ) (*Tree, error) {
@@ -393,6 +705,24 @@ func parseTree(
 		return nil, fmt.Errorf("getting num nodes as int64: %w", err)
 	}
 
+	if err := checkScalarLeaves(xt.TreeParam); err != nil {
+		return nil, err
+	}
+
+	if err := checkArrayLengths(xt, numNodes); err != nil {
+		return nil, err
+	}
+
+	numDeleted, err := parseNumDeleted(xt.TreeParam, numNodes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkStructure(xt.LeftChildren, xt.RightChildren, numDeleted)
+	if err != nil {
+		return nil, err
+	}
+
 	categories, err := categorySets(xt, numNodes)
 	if err != nil {
 		return nil, err
@@ -411,6 +741,10 @@ func parseTree(
 			return nil, err
 		}
 
+		if err := checkSumHessian(int(i), xt.SumHessian[i]); err != nil {
+			return nil, err
+		}
+
 		nodes[i].Data = NodeData{
 			BaseWeight:     xt.BaseWeights[i],
 			DefaultLeft:    xt.DefaultLeft[i] == 1,
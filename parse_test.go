@@ -1,8 +1,10 @@
 package xgbshap
 
 import (
+	"bytes"
 	"encoding/json"
 	"math"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -85,7 +87,7 @@ func TestXGBFloatUnmarshalJSON(t *testing.T) {
 }
 
 func TestParseModelNegInfSplit(t *testing.T) {
-	_, trees, err := parseModel("testdata/neg-inf-split/model.json")
+	_, trees, err := parseModel(readFile(t, "testdata/neg-inf-split/model.json"))
 	require.NoError(t, err)
 
 	require.Len(t, trees, 1)
@@ -102,7 +104,7 @@ func TestParseModelNegInfSplit(t *testing.T) {
 }
 
 func TestParseModelCategorical(t *testing.T) {
-	_, trees, err := parseModel("testdata/categorical/model.json")
+	_, trees, err := parseModel(readFile(t, "testdata/categorical/model.json"))
 	require.NoError(t, err)
 
 	require.Len(t, trees, 1)
@@ -255,9 +257,412 @@ func TestCheckSplitCondition(t *testing.T) {
 	})
 }
 
+func TestCheckSumHessian(t *testing.T) {
+	t.Run("positive sums accepted", func(t *testing.T) {
+		require.NoError(t, checkSumHessian(0, 1.5))
+	})
+	t.Run("zero accepted", func(t *testing.T) {
+		require.NoError(t, checkSumHessian(0, 0))
+	})
+	t.Run("negative rejected", func(t *testing.T) {
+		require.EqualError(
+			t,
+			checkSumHessian(2, -1),
+			"node 2 has invalid sum of hessians (-1); it must be finite and "+
+				"non-negative",
+		)
+	})
+	t.Run("infinity rejected", func(t *testing.T) {
+		require.Error(t, checkSumHessian(0, float32(math.Inf(1))))
+	})
+	t.Run("NaN rejected", func(t *testing.T) {
+		require.Error(t, checkSumHessian(0, float32(math.NaN())))
+	})
+}
+
+// TestParseModelSumHessian checks that models with zero sums of hessians,
+// which XGBoost loads, give margins and finite contributions. The model's
+// leaves are 0, 0 and 4, reached by x0 < 0.5, x1 < 0.5 and otherwise.
+func TestParseModelSumHessian(t *testing.T) {
+	model := readFile(t, "testdata/interaction/model.json")
+	const hessian = `"sum_hessian": [4.0, 2.0, 2.0, 1.0, 1.0]`
+
+	tests := []struct {
+		name     string
+		hessian  string
+		wantBias float32
+	}{
+		{
+			name:     "zero leaf hessian",
+			hessian:  `"sum_hessian": [4.0, 2.0, 2.0, 0.0, 2.0]`,
+			wantBias: 2,
+		},
+		{
+			// The children of node 2 are weighted evenly, so its mean value
+			// is 2 and the root's is (0 + 2) / 2.
+			name:     "zero decision node hessian",
+			hessian:  `"sum_hessian": [1.0, 1.0, 0.0, 0.0, 0.0]`,
+			wantBias: 0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := bytes.Replace(model, []byte(hessian), []byte(test.hessian), 1)
+			require.NotEqual(t, model, buf)
+
+			p, err := NewPredictorFromBytes(buf)
+			require.NoError(t, err)
+
+			for _, x := range [][]*float32{
+				{toPtr(0), toPtr(0)},
+				{toPtr(1), toPtr(0)},
+				{toPtr(1), toPtr(1)},
+			} {
+				margin, err := p.PredictMargin(x)
+				require.NoError(t, err)
+
+				contributions, err := p.PredictContributions(x)
+				require.NoError(t, err)
+				for _, c := range contributions {
+					assert.False(t, math.IsNaN(float64(c)) || math.IsInf(float64(c), 0))
+				}
+				assert.Equal(t, test.wantBias, contributions[2])
+				assert.InDelta(t, margin[0], contributions[0]+contributions[1]+contributions[2], 1e-5)
+			}
+		})
+	}
+}
+
+func TestResolveBooster(t *testing.T) {
+	model := Model{TreeInfo: []int{0, 0}, Trees: make([]XGBTree, 2)}
+
+	t.Run("gbtree", func(t *testing.T) {
+		gb := GradientBooster{Name: "gbtree", Model: model}
+		require.NoError(t, resolveBooster(&gb))
+		assert.Equal(t, model, gb.Model)
+		assert.Nil(t, gb.WeightDrop)
+	})
+
+	t.Run("unnamed booster is gbtree", func(t *testing.T) {
+		gb := GradientBooster{Model: model}
+		require.NoError(t, resolveBooster(&gb))
+		assert.Equal(t, model, gb.Model)
+	})
+
+	t.Run("dart uses the nested gbtree model", func(t *testing.T) {
+		gb := GradientBooster{
+			Name:       "dart",
+			GBTree:     &GradientBooster{Name: "gbtree", Model: model},
+			WeightDrop: []float32{0.5, 1},
+		}
+		require.NoError(t, resolveBooster(&gb))
+		assert.Equal(t, model, gb.Model)
+	})
+
+	tests := []struct {
+		name    string
+		gb      GradientBooster
+		wantErr string
+	}{
+		{
+			name:    "unsupported booster",
+			gb:      GradientBooster{Name: "gblinear"},
+			wantErr: `unsupported booster "gblinear"`,
+		},
+		{
+			name: "gbtree with weights",
+			gb: GradientBooster{
+				Name:       "gbtree",
+				Model:      model,
+				WeightDrop: []float32{1},
+			},
+			wantErr: "weight_drop is only supported for dart boosters",
+		},
+		{
+			name:    "dart without gbtree",
+			gb:      GradientBooster{Name: "dart", WeightDrop: []float32{1}},
+			wantErr: "dart booster has no gbtree model",
+		},
+		{
+			name: "dart with the wrong number of weights",
+			gb: GradientBooster{
+				Name:       "dart",
+				GBTree:     &GradientBooster{Model: model},
+				WeightDrop: []float32{1},
+			},
+			wantErr: "weight_drop has 1 entries but the model has 2 trees",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.EqualError(t, resolveBooster(&test.gb), test.wantErr)
+		})
+	}
+}
+
+func TestParseTreeStructure(t *testing.T) {
+	// baseTree is a valid five-node tree the error cases mutate. The root's
+	// children are not in adjacent slots, which xgboost never writes but which
+	// is still a well-formed tree.
+	baseTree := func() XGBTree {
+		xt := XGBTree{
+			BaseWeights:     []float32{0, 1, 0, 3, 4},
+			DefaultLeft:     []int{1, 0, 0, 0, 0},
+			LeftChildren:    []int{1, -1, 3, -1, -1},
+			RightChildren:   []int{2, -1, 4, -1, -1},
+			SplitConditions: []xgbFloat{0.5, 1, 0.5, 3, 4},
+			SplitIndices:    []int{0, 0, 1, 0, 0},
+			SumHessian:      []float32{3, 1, 2, 1, 1},
+		}
+		xt.TreeParam.NumNodes = "5"
+		return xt
+	}
+
+	t.Run("valid tree", func(t *testing.T) {
+		tree, err := parseTree(baseTree())
+		require.NoError(t, err)
+		assert.Equal(t, 5, tree.NumNodes)
+	})
+
+	t.Run("scalar leaves", func(t *testing.T) {
+		for _, size := range []json.Number{"0", "1"} {
+			xt := baseTree()
+			xt.TreeParam.SizeLeafVector = size
+			_, err := parseTree(xt)
+			require.NoError(t, err, "size_leaf_vector %s", size)
+		}
+	})
+
+	t.Run("deleted nodes", func(t *testing.T) {
+		// Pruning node 2 turns it into a leaf and deletes its children, which
+		// xgboost keeps in place as unreachable leaves.
+		xt := baseTree()
+		xt.LeftChildren[2] = -1
+		xt.RightChildren[2] = -1
+		xt.BaseWeights[2] = 2
+		xt.SplitConditions[2] = 2
+		xt.SplitIndices[3] = math.MaxInt32
+		xt.SplitIndices[4] = math.MaxInt32
+		xt.TreeParam.NumDeleted = "2"
+
+		tree, err := parseTree(xt)
+		require.NoError(t, err)
+
+		assert.InDelta(t, float32(1), predictValue(tree, []*float32{toPtr(0), toPtr(0)}), 1e-6)
+		assert.InDelta(t, float32(2), predictValue(tree, []*float32{toPtr(1), toPtr(1)}), 1e-6)
+	})
+
+	t.Run("right child need not follow left child", func(t *testing.T) {
+		xt := baseTree()
+		// Swap the slots of nodes 1 and 2 so the root's right child comes
+		// before its left child.
+		xt.BaseWeights = []float32{0, 0, 1, 3, 4}
+		xt.LeftChildren = []int{2, 3, -1, -1, -1}
+		xt.RightChildren = []int{1, 4, -1, -1, -1}
+		xt.SplitConditions = []xgbFloat{0.5, 0.5, 1, 3, 4}
+		xt.SplitIndices = []int{0, 1, 0, 0, 0}
+		xt.SumHessian = []float32{3, 2, 1, 1, 1}
+
+		tree, err := parseTree(xt)
+		require.NoError(t, err)
+
+		assert.InDelta(t, float32(1), predictValue(tree, []*float32{toPtr(0), toPtr(0)}), 1e-6)
+		assert.InDelta(t, float32(3), predictValue(tree, []*float32{toPtr(1), toPtr(0)}), 1e-6)
+		assert.InDelta(t, float32(4), predictValue(tree, []*float32{toPtr(1), toPtr(1)}), 1e-6)
+	})
+
+	tests := []struct {
+		name    string
+		mutate  func(xt *XGBTree)
+		wantErr string
+	}{
+		{
+			name: "no nodes",
+			mutate: func(xt *XGBTree) {
+				xt.TreeParam.NumNodes = "0"
+			},
+			wantErr: "invalid num_nodes 0",
+		},
+		{
+			name: "vector leaves",
+			mutate: func(xt *XGBTree) {
+				xt.TreeParam.SizeLeafVector = "3"
+			},
+			wantErr: "tree has 3 values per leaf; multi-target trees with " +
+				"vector leaves are not supported",
+		},
+		{
+			name: "negative size_leaf_vector",
+			mutate: func(xt *XGBTree) {
+				xt.TreeParam.SizeLeafVector = "-1"
+			},
+			wantErr: "invalid size_leaf_vector: -1",
+		},
+		{
+			name: "short array",
+			mutate: func(xt *XGBTree) {
+				xt.SumHessian = xt.SumHessian[:4]
+			},
+			wantErr: "sum_hessian has 4 entries but num_nodes is 5",
+		},
+		{
+			name: "num_nodes larger than arrays",
+			mutate: func(xt *XGBTree) {
+				xt.TreeParam.NumNodes = "6"
+			},
+			wantErr: "base_weights has 5 entries but num_nodes is 6",
+		},
+		{
+			name: "only one child",
+			mutate: func(xt *XGBTree) {
+				xt.RightChildren[2] = -1
+			},
+			wantErr: "node 2 has only one child",
+		},
+		{
+			name: "same child twice",
+			mutate: func(xt *XGBTree) {
+				xt.RightChildren[2] = 3
+			},
+			wantErr: "node 2 has the same left and right child 3",
+		},
+		{
+			name: "child out of range",
+			mutate: func(xt *XGBTree) {
+				xt.RightChildren[2] = 5
+			},
+			wantErr: "node 2 has child 5, which is out of range",
+		},
+		{
+			name: "negative child",
+			mutate: func(xt *XGBTree) {
+				xt.RightChildren[2] = -2
+			},
+			wantErr: "node 2 has child -2, which is out of range",
+		},
+		{
+			name: "root as child",
+			mutate: func(xt *XGBTree) {
+				xt.LeftChildren[2] = 0
+			},
+			wantErr: "node 2 has child 0, which is out of range",
+		},
+		{
+			name: "shared child",
+			mutate: func(xt *XGBTree) {
+				xt.LeftChildren[2] = 1
+			},
+			wantErr: "node 1 is a child of both node 0 and node 2",
+		},
+		{
+			name: "detached cycle",
+			mutate: func(xt *XGBTree) {
+				// Nodes 3 and 4 are each other's parent, so every node but
+				// the root has exactly one parent, yet neither is reachable.
+				xt.TreeParam.NumNodes = "7"
+				xt.BaseWeights = make([]float32, 7)
+				xt.DefaultLeft = make([]int, 7)
+				xt.LeftChildren = []int{1, -1, -1, 4, 3, -1, -1}
+				xt.RightChildren = []int{2, -1, -1, 5, 6, -1, -1}
+				xt.SplitConditions = make([]xgbFloat, 7)
+				xt.SplitIndices = make([]int, 7)
+				xt.SumHessian = make([]float32, 7)
+			},
+			wantErr: "node 3 is not reachable from the root",
+		},
+		{
+			name: "unreachable node",
+			mutate: func(xt *XGBTree) {
+				xt.LeftChildren[2] = -1
+				xt.RightChildren[2] = -1
+			},
+			wantErr: "node 3 is not reachable from the root and num_deleted is 0",
+		},
+		{
+			name: "more unreachable leaves than deleted nodes",
+			mutate: func(xt *XGBTree) {
+				xt.LeftChildren[2] = -1
+				xt.RightChildren[2] = -1
+				xt.TreeParam.NumDeleted = "1"
+			},
+			wantErr: "node 4 is not reachable from the root and num_deleted is 1",
+		},
+		{
+			name: "negative num_deleted",
+			mutate: func(xt *XGBTree) {
+				xt.TreeParam.NumDeleted = "-1"
+			},
+			wantErr: "invalid num_deleted -1 for 5 nodes",
+		},
+		{
+			name: "num_deleted includes the root",
+			mutate: func(xt *XGBTree) {
+				xt.TreeParam.NumDeleted = "5"
+			},
+			wantErr: "invalid num_deleted 5 for 5 nodes",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			xt := baseTree()
+			test.mutate(&xt)
+			_, err := parseTree(xt)
+			require.ErrorContains(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestCheckStructureDepth(t *testing.T) {
+	// chain returns the child arrays of a tree of the given depth in which
+	// each node's left child is a leaf and its right child continues the
+	// chain.
+	chain := func(depth int) ([]int, []int) {
+		left := make([]int, 2*depth+1)
+		right := make([]int, 2*depth+1)
+		for i := range left {
+			left[i], right[i] = -1, -1
+		}
+		for d := range depth {
+			left[2*d] = 2*d + 1
+			right[2*d] = 2*d + 2
+		}
+		return left, right
+	}
+
+	left, right := chain(maxTreeDepth)
+	require.NoError(t, checkStructure(left, right, 0))
+
+	left, right = chain(maxTreeDepth + 1)
+	require.EqualError(
+		t,
+		checkStructure(left, right, 0),
+		"tree is deeper than the maximum depth of 1024",
+	)
+}
+
+func TestParseModelTreeErrorIncludesIndex(t *testing.T) {
+	buf := bytes.Replace(
+		readFile(t, "testdata/interaction/model.json"),
+		[]byte(`"right_children": [2, -1, 4, -1, -1]`),
+		[]byte(`"right_children": [99, -1, 4, -1, -1]`),
+		1,
+	)
+
+	_, _, err := parseModel(buf)
+	require.ErrorContains(t, err, "tree 0: node 0 has child 99")
+}
+
 func BenchmarkParseModel(b *testing.B) {
+	buf := readFile(b, "testdata/small-model/model.json")
+
 	for b.Loop() {
-		_, _, err := parseModel("testdata/small-model/model.json")
+		_, _, err := parseModel(buf)
 		require.NoError(b, err)
 	}
 }
+
+func readFile(t testing.TB, path string) []byte {
+	buf, err := os.ReadFile(path) //nolint:gosec // path is a test fixture, not user input
+	require.NoError(t, err)
+	return buf
+}
@@ -0,0 +1,90 @@
+package xgbshap
+
+// Much of this code is ported from the xgboost C++ code.
+//
+// Copyright by XGBoost Contributors 2017-2023
+//
+// xgboost's code is Apache 2.0 licensed.
+
+// PredictMargin returns the model's untransformed prediction for each output
+// group. This is what XGBoost returns with output_margin=True: the base margin
+// plus the sum of the leaf values the features reach.
+func (p *Predictor) PredictMargin(
+	features []*float32,
+	opts ...PredictOption,
+) ([]float32, error) {
+	o, err := p.applyPredictOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.predictMargin(features, &o)
+}
+
+// Predict returns the model's prediction for each output group, i.e., the
+// margin transformed by the model's objective. For example, this is the
+// probability of the positive class for binary:logistic and the probability
+// of each class for multi:softprob. For multi:softmax it is a single value,
+// the index of the predicted class, as in XGBoost.
+func (p *Predictor) Predict(
+	features []*float32,
+	opts ...PredictOption,
+) ([]float32, error) {
+	margin, err := p.PredictMargin(features, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return predTransform(p.objective, margin), nil
+}
+
+// This is equivalent to PredictBatch() in xgboost's cpu_predictor.cc for a
+// single row.
+func (p *Predictor) predictMargin(
+	features []*float32,
+	o *PredictOptions,
+) ([]float32, error) {
+	if err := p.checkFeatureCount(len(features)); err != nil {
+		return nil, err
+	}
+
+	features, err := p.checkCategories(features)
+	if err != nil {
+		return nil, err
+	}
+
+	margin := make([]float32, p.numGroup)
+	if o.baseMargin != nil {
+		copy(margin, o.baseMargin)
+	} else {
+		copy(margin, p.baseMargin)
+	}
+
+	treeBegin, treeEnd := p.treeRange(o)
+	for i := treeBegin; i < treeEnd; i++ {
+		margin[p.treeGroups[i]] += predictValue(p.trees[i], features) *
+			p.treeWeight(i)
+	}
+
+	return margin, nil
+}
+
+// predictValue returns the value of the leaf that features reach in tree.
+//
+// This is equivalent to GetLeafIndex() followed by LeafValue() in xgboost.
+func predictValue(tree *Tree, features []*float32) float32 {
+	nodeIndex := 0
+	for !tree.Nodes[nodeIndex].IsLeaf() {
+		node := &tree.Nodes[nodeIndex]
+		featureValue := features[node.Data.SplitIndex]
+		nodeIndex = getNextNode(
+			true, // We always can have missing values.
+			node,
+			nodeIndex,
+			featureValue,
+			isMissingValue(featureValue),
+		)
+	}
+
+	return tree.Nodes[nodeIndex].LeafValue()
+}
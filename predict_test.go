@@ -0,0 +1,112 @@
+package xgbshap
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPredictMulticlass(t *testing.T) {
+	p, err := NewPredictor("testdata/multiclass/model.json")
+	require.NoError(t, err)
+
+	features := []*float32{toPtr(1.0), toPtr(0.5)}
+
+	// base_score 0.5 plus each class's leaf values.
+	margin, err := p.PredictMargin(features)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{0.25, 1.375, -1.25}, margin)
+
+	probs, err := p.Predict(features)
+	require.NoError(t, err)
+
+	var sum float64
+	for _, m := range margin {
+		sum += math.Exp(float64(m))
+	}
+	require.Len(t, probs, 3)
+	for i, m := range margin {
+		assert.InDelta(t, math.Exp(float64(m))/sum, probs[i], 1e-6)
+	}
+
+	t.Run("base margin replaces base_score", func(t *testing.T) {
+		margin, err := p.PredictMargin(features, BaseMargin(0, 0, 0))
+		require.NoError(t, err)
+		assert.Equal(t, []float32{-0.25, 0.875, -1.75}, margin)
+	})
+}
+
+func TestPredictMatchesContributions(t *testing.T) {
+	// SHAP values are additive: the contributions and bias sum to the margin.
+	// The roundtrip golden contributions come from XGBoost, so this also checks
+	// the margin against XGBoost.
+	p, err := NewPredictor("testdata/roundtrip/model.json")
+	require.NoError(t, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
+	allContribs, err := readContributionsCSV("testdata/roundtrip/contributions.csv")
+	require.NoError(t, err)
+
+	for row, features := range allFeatures {
+		var want float64
+		for _, c := range allContribs[row] {
+			want += float64(c)
+		}
+
+		margin, err := p.PredictMargin(features)
+		require.NoError(t, err)
+		require.Len(t, margin, 1)
+		assert.InDelta(t, want, margin[0], 1e-4, "row %d", row)
+
+		prob, err := p.Predict(features)
+		require.NoError(t, err)
+		require.Len(t, prob, 1)
+		assert.InDelta(t, 1/(1+math.Exp(-want)), prob[0], 1e-5, "row %d", row)
+	}
+}
+
+func TestPredictRouting(t *testing.T) {
+	// These models have no objective or base_score, so the prediction is the
+	// leaf value.
+	t.Run("categorical", func(t *testing.T) {
+		p, err := NewPredictor("testdata/categorical/model.json")
+		require.NoError(t, err)
+
+		for _, test := range []struct {
+			feature *float32
+			want    float32
+		}{
+			{feature: toPtr(1), want: 30},
+			{feature: toPtr(3), want: 30},
+			{feature: toPtr(2), want: 10},
+			{feature: nil, want: 10},
+		} {
+			got, err := p.Predict([]*float32{test.feature})
+			require.NoError(t, err)
+			assert.Equal(t, []float32{test.want}, got)
+		}
+	})
+
+	t.Run("-Infinity split", func(t *testing.T) {
+		p, err := NewPredictor("testdata/neg-inf-split/model.json")
+		require.NoError(t, err)
+
+		got, err := p.Predict([]*float32{toPtr(-1e30)})
+		require.NoError(t, err)
+		assert.Equal(t, []float32{20}, got)
+
+		got, err = p.Predict([]*float32{nil})
+		require.NoError(t, err)
+		assert.Equal(t, []float32{10}, got)
+
+		// NaN is missing, so it follows the default direction rather than
+		// comparing against the split condition.
+		got, err = p.Predict([]*float32{toPtr(float32(math.NaN()))})
+		require.NoError(t, err)
+		assert.Equal(t, []float32{10}, got)
+	})
+}
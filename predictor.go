@@ -1,4 +1,5 @@
-// Package xgbshap calculates feature contributions for XGBoost models.
+// Package xgbshap calculates feature contributions and predictions for XGBoost
+// models.
 package xgbshap
 
 // Much of this code is ported from the xgboost C++ code.
@@ -8,12 +9,30 @@ package xgbshap
 // xgboost's code is Apache 2.0 licensed.
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
 )
 
 // Options holds Predictor options.
 type Options struct {
-	ntreeLimit int
+	ntreeLimit      int
+	clampNtreeLimit bool
+	clampWarning    func(string)
+	iterationRange  *iterationRange
+	unknownFeatures UnknownFeaturePolicy
+	missingFeatures MissingFeaturePolicy
+	invalidCategory InvalidCategoryPolicy
+	missing         float32
+	hasMissing      bool
+
+	verifyAdditivity    bool
+	additivityTolerance float32
+	additivityHook      func(*AdditivityError)
 }
 
 // Option is a configuration function.
@@ -23,70 +42,779 @@ type Option func(*Options)
 //
 // For newer XGBoost models, this is found in the model file, so it does not
 // need to be provided.
+//
+// As in XGBoost, the limit counts the trees of a single output group, so for
+// multi-class models, where each boosting round has one tree per class, it is
+// a number of boosting rounds. For random forests, where each round has
+// num_parallel_tree trees per output group, it is divided by
+// num_parallel_tree, rounding down, to get a number of rounds. A limit
+// smaller than num_parallel_tree therefore selects no rounds, which XGBoost
+// treats as no limit, so all of the model's trees are used.
 func NtreeLimit(ntreeLimit int) func(*Options) {
 	return func(o *Options) {
 		o.ntreeLimit = ntreeLimit
 	}
 }
 
-// Predictor calculates feature contributions for an XGBoost model.
+// ClampNtreeLimit makes NewPredictor use all of the model's trees when the
+// ntree limit, whether set with NtreeLimit or read from the model, is larger
+// than the number of trees. By default, this is an error. If warn is not nil,
+// it is called with a description of the problem when the limit is clamped.
+func ClampNtreeLimit(warn func(msg string)) func(*Options) {
+	return func(o *Options) {
+		o.clampNtreeLimit = true
+		o.clampWarning = warn
+	}
+}
+
+// IterationRange restricts predictions to the trees of the boosting rounds
+// from begin up to, but not including, end, like the iteration_range
+// parameter of XGBoost's predict methods. An end of 0 means the last round.
+// It may not be combined with NtreeLimit, and it takes precedence over the
+// best iteration recorded in the model.
+//
+// For example, IterationRange(10, 20) explains the contribution of the
+// eleventh through twentieth rounds. The bias then only includes the
+// expected values of those rounds' trees.
+func IterationRange(begin, end int) func(*Options) {
+	return func(o *Options) {
+		o.iterationRange = &iterationRange{begin: begin, end: end}
+	}
+}
+
+// iterationRange is a range of boosting rounds, which XGBoost calls layers.
+type iterationRange struct {
+	begin int
+	end   int
+}
+
+// Missing sets a value that marks a feature as missing in the input to
+// PredictContributionsDense and the sparse prediction methods, like the
+// missing parameter of an XGBoost DMatrix. NaN is always treated as missing.
+func Missing(value float32) func(*Options) {
+	return func(o *Options) {
+		o.missing = value
+		o.hasMissing = true
+	}
+}
+
+// VerifyAdditivity makes each contribution calculation check that the
+// contributions sum to the margin, which it predicts separately by walking
+// the trees, as shap's check_additivity does. A sum that differs from the
+// margin by more than tolerance indicates a bug, such as a base_score that
+// was not converted to margin space correctly, and makes the calculation
+// return an *AdditivityError. Walking the trees costs little next to
+// calculating the contributions.
+//
+// The check applies to every contribution method except
+// PredictConditionalContributions, whose results do not sum to the margin.
+func VerifyAdditivity(tolerance float32) func(*Options) {
+	return func(o *Options) {
+		o.verifyAdditivity = true
+		o.additivityTolerance = tolerance
+	}
+}
+
+// OnAdditivityError sets a function that VerifyAdditivity's check calls
+// with each failure instead of returning an error, so that a failed check
+// can be logged without failing the calculation. It may be called
+// concurrently by the batch methods. It has no effect without
+// VerifyAdditivity.
+func OnAdditivityError(hook func(err *AdditivityError)) func(*Options) {
+	return func(o *Options) {
+		o.additivityHook = hook
+	}
+}
+
+// UnknownFeaturePolicy determines how PredictContributionsMap handles a name
+// that is not one of the model's features.
+type UnknownFeaturePolicy int
+
+const (
+	// UnknownFeatureError makes the prediction return an error. This is the
+	// default, as an unknown name is often a misspelling of a feature that
+	// would otherwise silently be treated as missing.
+	UnknownFeatureError UnknownFeaturePolicy = iota
+	// UnknownFeatureIgnore ignores the name and its value.
+	UnknownFeatureIgnore
+)
+
+// MissingFeaturePolicy determines how PredictContributionsMap handles a model
+// feature that has no entry in the map.
+type MissingFeaturePolicy int
+
+const (
+	// MissingFeatureAsMissing treats the feature as missing, i.e., it follows
+	// the default direction at each split, as a nil feature does in
+	// PredictContributions. This is the default.
+	MissingFeatureAsMissing MissingFeaturePolicy = iota
+	// MissingFeatureError makes the prediction return an error.
+	MissingFeatureError
+)
+
+// OnUnknownFeature sets how PredictContributionsMap handles names that are
+// not features of the model.
+func OnUnknownFeature(policy UnknownFeaturePolicy) func(*Options) {
+	return func(o *Options) {
+		o.unknownFeatures = policy
+	}
+}
+
+// OnMissingFeature sets how PredictContributionsMap handles features of the
+// model that are absent from the map.
+func OnMissingFeature(policy MissingFeaturePolicy) func(*Options) {
+	return func(o *Options) {
+		o.missingFeatures = policy
+	}
+}
+
+// InvalidCategoryPolicy determines how predictions handle a value of a
+// categorical feature that is not a valid category. Valid categories are
+// integers from 0 up to, but not including, 2^24.
+type InvalidCategoryPolicy int
+
+const (
+	// InvalidCategoryXGBoost handles invalid categories as XGBoost does:
+	// non-integer values are truncated toward zero, and negative, non-finite,
+	// and too large values follow the left branch of every categorical split,
+	// as categories that are not in the split's set do. This is the default.
+	InvalidCategoryXGBoost InvalidCategoryPolicy = iota
+	// InvalidCategoryError makes the prediction return a *CategoryError.
+	InvalidCategoryError
+	// InvalidCategoryMissing treats an invalid category as a missing value,
+	// i.e., it follows the default direction at each split.
+	InvalidCategoryMissing
+)
+
+// OnInvalidCategory sets how predictions handle values of categorical
+// features that are not valid categories.
+func OnInvalidCategory(policy InvalidCategoryPolicy) func(*Options) {
+	return func(o *Options) {
+		o.invalidCategory = policy
+	}
+}
+
+// PredictOptions holds options for a single prediction.
+type PredictOptions struct {
+	baseMargin     []float32
+	iterationRange *iterationRange
+}
+
+// PredictOption is a configuration function for a single prediction.
+type PredictOption func(*PredictOptions)
+
+// BaseMargin sets the row's base margin, one value per output group. As with
+// the base_margin of an XGBoost DMatrix, it replaces the model's base_score as
+// the starting point of the prediction and is added to the bias. It is in
+// margin space, i.e., before the objective's transformation, so it is not
+// transformed the way base_score is.
+func BaseMargin(margins ...float32) func(*PredictOptions) {
+	return func(o *PredictOptions) {
+		o.baseMargin = margins
+	}
+}
+
+// Iterations restricts a single prediction to the trees of the boosting
+// rounds from begin up to, but not including, end. An end of 0 means the last
+// round. It overrides the Predictor's ntree limit or IterationRange for the
+// call.
+func Iterations(begin, end int) func(*PredictOptions) {
+	return func(o *PredictOptions) {
+		o.iterationRange = &iterationRange{begin: begin, end: end}
+	}
+}
+
+// Predictor calculates predictions and feature contributions for an XGBoost
+// model.
+//
+// A Predictor is not modified after it is created, so it is safe for
+// concurrent use by multiple goroutines.
 type Predictor struct {
-	ntreeLimit int
+	// numParallelTree is the number of trees of each output group in each
+	// boosting round. It is greater than 1 for random forests.
+	numParallelTree int
+	// numLayers is the number of boosting rounds, which XGBoost calls layers,
+	// in the model.
+	numLayers int
+	// layerBegin and layerEnd delimit the boosting rounds whose trees
+	// predictions use.
+	layerBegin int
+	layerEnd   int
+	// numFeature is the number of features that predictions require.
+	numFeature int
+	// numGroup is the number of output groups. It is the number of classes
+	// for multi-class models and 1 otherwise.
+	numGroup int
+	// treeGroups holds the output group of each tree.
+	treeGroups []int
 	trees      []*Tree
+	// treeWeights holds the weight of each tree for DART models. It is nil
+	// for gbtree models, where every tree has a weight of 1.
+	treeWeights []float32
+	// baseMargin holds the model's base_score for each output group,
+	// transformed into margin space.
+	baseMargin []float32
+	objective  string
+	// featureNames holds the model's feature names, if any, and
+	// featureIndexes maps each name to its column.
+	featureNames    []string
+	featureIndexes  map[string]int
+	unknownFeatures UnknownFeaturePolicy
+	missingFeatures MissingFeaturePolicy
+	// categoricalFeatures holds the indexes of the categorical features.
+	categoricalFeatures []int
+	invalidCategory     InvalidCategoryPolicy
+	// missing is the value set with the Missing option, if hasMissing is
+	// true.
+	missing    float32
+	hasMissing bool
+	// verifyAdditivity, additivityTolerance, and additivityHook are set with
+	// the VerifyAdditivity and OnAdditivityError options.
+	verifyAdditivity    bool
+	additivityTolerance float32
+	additivityHook      func(*AdditivityError)
 }
 
-// NewPredictor creates a Predictor.
+// NewPredictor creates a Predictor from the XGBoost model file at modelFile.
 func NewPredictor(
 	modelFile string,
 	opts ...Option,
+) (*Predictor, error) {
+	buf, err := os.ReadFile(filepath.Clean(modelFile))
+	if err != nil {
+		return nil, fmt.Errorf("reading file: %w", err)
+	}
+
+	return NewPredictorFromBytes(buf, opts...)
+}
+
+// NewPredictorFromReader creates a Predictor from an XGBoost model read from
+// r. The reader is consumed until EOF. This is useful for models that are
+// embedded in the binary or fetched from remote storage.
+func NewPredictorFromReader(
+	r io.Reader,
+	opts ...Option,
+) (*Predictor, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading model: %w", err)
+	}
+
+	return NewPredictorFromBytes(buf, opts...)
+}
+
+// NewPredictorFromBytes creates a Predictor from an XGBoost model held in
+// memory. The Predictor does not retain buf.
+func NewPredictorFromBytes(
+	buf []byte,
+	opts ...Option,
 ) (*Predictor, error) {
 	var o Options
 	for _, f := range opts {
 		f(&o)
 	}
 
-	xgbModel, trees, err := parseModel(modelFile)
+	// The negated comparison also rejects NaN.
+	if o.verifyAdditivity && !(o.additivityTolerance >= 0) {
+		return nil, fmt.Errorf(
+			"invalid additivity tolerance: %v",
+			o.additivityTolerance,
+		)
+	}
+
+	xgbModel, trees, err := parseModel(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tree := range trees {
+		tree.fillDerivedValues()
+	}
+
+	numGroup, treeGroups, err := resolveTreeGroups(xgbModel, len(trees))
 	if err != nil {
 		return nil, err
 	}
 
-	if o.ntreeLimit == 0 {
-		o.ntreeLimit, err = resolveNtreeLimit(
+	numFeature, err := resolveNumFeature(
+		xgbModel.Learner.LearnerModelParam,
+		trees,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	featureIndexes, err := resolveFeatureNames(
+		xgbModel.Learner.FeatureNames,
+		numFeature,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	categoricalFeatures, err := resolveCategoricalFeatures(
+		xgbModel.Learner.FeatureTypes,
+		numFeature,
+		trees,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	baseMargin, err := resolveBaseMargin(xgbModel.Learner, numGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	numParallelTree, err := resolveNumParallelTree(
+		xgbModel.Learner.GradientBooster.Model.GBTreeModelParam,
+		len(trees),
+		numGroup,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	numLayers := len(trees) / (numGroup * numParallelTree)
+
+	var layerBegin, layerEnd int
+	if o.iterationRange != nil {
+		if o.ntreeLimit != 0 {
+			return nil, errors.New(
+				"IterationRange and NtreeLimit may not both be set",
+			)
+		}
+		layerBegin, layerEnd, err = o.iterationRange.resolve(numLayers)
+	} else {
+		layerEnd, err = resolveLayerEnd(
+			&o,
 			xgbModel.Learner.Attributes,
-			len(trees),
+			numLayers,
+			numParallelTree,
 		)
-		if err != nil {
-			return nil, err
-		}
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	return &Predictor{
-		ntreeLimit: o.ntreeLimit,
+		numParallelTree: numParallelTree,
+		numLayers:       numLayers,
+		layerBegin:      layerBegin,
+		layerEnd:        layerEnd,
+
+		numFeature: numFeature,
+		numGroup:   numGroup,
+		treeGroups: treeGroups,
 		trees:      trees,
+		baseMargin: baseMargin,
+		objective:  xgbModel.Learner.Objective.Name,
+
+		treeWeights: xgbModel.Learner.GradientBooster.WeightDrop,
+
+		featureNames:    xgbModel.Learner.FeatureNames,
+		featureIndexes:  featureIndexes,
+		unknownFeatures: o.unknownFeatures,
+		missingFeatures: o.missingFeatures,
+
+		categoricalFeatures: categoricalFeatures,
+		invalidCategory:     o.invalidCategory,
+
+		missing:    o.missing,
+		hasMissing: o.hasMissing,
+
+		verifyAdditivity:    o.verifyAdditivity,
+		additivityTolerance: o.additivityTolerance,
+		additivityHook:      o.additivityHook,
 	}, nil
 }
 
-// resolveNtreeLimit determines how many trees to use when the caller has not
-// set an explicit limit. Older XGBoost versions store best_ntree_limit
-// directly; newer ones store best_iteration (0-based), so the number of trees
-// to use is best_iteration + 1. When neither attribute is present there was no
-// early stopping, so all trees are used. best_ntree_limit takes precedence when
-// both are present.
-func resolveNtreeLimit(attrs Attributes, numTrees int) (int, error) {
+// resolveNumFeature determines how many features predictions require and
+// checks that every split is on one of them. It is num_feature when the model
+// records it and otherwise one more than the largest feature index that any
+// tree splits on.
+func resolveNumFeature(param LearnerModelParam, trees []*Tree) (int, error) {
+	numFeature := -1
+	if param.NumFeature != "" {
+		n, err := param.NumFeature.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("parsing num_feature: %w", err)
+		}
+		if n < 0 || n > math.MaxInt32 {
+			return 0, fmt.Errorf("invalid num_feature: %d", n)
+		}
+		numFeature = int(n)
+	}
+
+	maxSplitIndex := -1
+	for i, tree := range trees {
+		for j := range tree.Nodes {
+			node := &tree.Nodes[j]
+			if node.IsLeaf() {
+				continue
+			}
+			splitIndex := node.Data.SplitIndex
+			if splitIndex < 0 || splitIndex >= math.MaxInt32 {
+				return 0, fmt.Errorf(
+					"tree %d: node %d splits on invalid feature %d",
+					i,
+					j,
+					splitIndex,
+				)
+			}
+			if numFeature >= 0 && splitIndex >= numFeature {
+				return 0, fmt.Errorf(
+					"tree %d: node %d splits on feature %d, which is out of "+
+						"range for num_feature %d",
+					i,
+					j,
+					splitIndex,
+					numFeature,
+				)
+			}
+			maxSplitIndex = max(maxSplitIndex, splitIndex)
+		}
+	}
+
+	if numFeature < 0 {
+		return maxSplitIndex + 1, nil
+	}
+	return numFeature, nil
+}
+
+// FeatureCountError is returned by predictions when the number of features
+// does not match the number that the model was trained with.
+type FeatureCountError struct {
+	// Got is the number of features provided.
+	Got int
+	// Want is the number of features the model expects.
+	Want int
+}
+
+func (e *FeatureCountError) Error() string {
+	return fmt.Sprintf(
+		"got %d features but the model expects %d",
+		e.Got,
+		e.Want,
+	)
+}
+
+// NumTrees returns the number of trees in the model, including any that the
+// ntree limit or iteration range excludes from predictions.
+func (p *Predictor) NumTrees() int {
+	return len(p.trees)
+}
+
+// NtreeLimit returns the ntree limit that predictions use, as resolved from
+// the NtreeLimit or IterationRange option or from the model. As with the
+// NtreeLimit option, it counts the trees of a single output group from the
+// first boosting round, so with an IterationRange that does not start at the
+// first round, it includes trees that predictions do not use.
+func (p *Predictor) NtreeLimit() int {
+	return p.layerEnd * p.numParallelTree
+}
+
+// treeWeight returns the weight of tree i.
+func (p *Predictor) treeWeight(i int) float32 {
+	if p.treeWeights == nil {
+		return 1
+	}
+	return p.treeWeights[i]
+}
+
+// checkFeatureCount returns a *FeatureCountError if n features do not match
+// the model.
+func (p *Predictor) checkFeatureCount(n int) error {
+	if n != p.numFeature {
+		return &FeatureCountError{Got: n, Want: p.numFeature}
+	}
+	return nil
+}
+
+// checkSingleGroup returns an error if the model has more than one output
+// group. method names the caller in the error.
+func (p *Predictor) checkSingleGroup(method string) error {
+	if p.numGroup != 1 {
+		return fmt.Errorf(
+			"model has %d output groups; %s requires exactly one",
+			p.numGroup,
+			method,
+		)
+	}
+	return nil
+}
+
+// resolveBaseMargin determines the margin-space bias of each output group from
+// the model's base_score and objective, as xgboost's learner does when it
+// loads a model. Models without a base_score get a bias of zero.
+func resolveBaseMargin(learner Learner, numGroup int) ([]float32, error) {
+	baseScores, err := parseBaseScore(
+		learner.LearnerModelParam.BaseScore,
+		numGroup,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	baseMargin := make([]float32, numGroup)
+	for i, score := range baseScores {
+		baseMargin[i], err = probToMargin(learner.Objective.Name, score)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return baseMargin, nil
+}
+
+// applyPredictOptions applies per-call options and validates them against
+// the model.
+func (p *Predictor) applyPredictOptions(
+	opts []PredictOption,
+) (PredictOptions, error) {
+	// Returning early keeps the common no-options case from allocating, as o
+	// escapes to the heap once it is passed to an option function.
+	if len(opts) == 0 {
+		return PredictOptions{}, nil
+	}
+
+	var o PredictOptions
+	for _, f := range opts {
+		f(&o)
+	}
+
+	if o.baseMargin != nil && len(o.baseMargin) != p.numGroup {
+		return o, fmt.Errorf(
+			"got %d base margin values; the model has %d output groups",
+			len(o.baseMargin),
+			p.numGroup,
+		)
+	}
+
+	if o.iterationRange != nil {
+		begin, end, err := o.iterationRange.resolve(p.numLayers)
+		if err != nil {
+			return o, err
+		}
+		o.iterationRange = &iterationRange{begin: begin, end: end}
+	}
+
+	return o, nil
+}
+
+// resolveTreeGroups determines the number of output groups and the group each
+// tree belongs to. Multi-class models train one tree per class in each
+// boosting round and record each tree's class in tree_info. Models with a
+// single output group may omit tree_info, in which case every tree is in
+// group 0.
+func resolveTreeGroups(xm *XGBModel, numTrees int) (int, []int, error) {
+	param := xm.Learner.LearnerModelParam
+
+	numGroup := 1
+	for _, n := range []struct {
+		name  string
+		value json.Number
+	}{
+		{"num_class", param.NumClass},
+		{"num_target", param.NumTarget},
+	} {
+		if n.value == "" {
+			continue
+		}
+		v, err := n.value.Int64()
+		if err != nil {
+			return 0, nil, fmt.Errorf("parsing %s: %w", n.name, err)
+		}
+		if v < 0 || v > math.MaxInt32 {
+			return 0, nil, fmt.Errorf("invalid %s: %d", n.name, v)
+		}
+		numGroup = max(numGroup, int(v))
+	}
+
+	// Every output group has at least one tree, so this rejects a corrupt
+	// group count before anything is allocated for each group.
+	if numGroup > 1 && numGroup > numTrees {
+		return 0, nil, fmt.Errorf(
+			"model has %d output groups but only %d trees",
+			numGroup,
+			numTrees,
+		)
+	}
+
+	treeInfo := xm.Learner.GradientBooster.Model.TreeInfo
+	if treeInfo == nil {
+		if numGroup != 1 {
+			return 0, nil, fmt.Errorf(
+				"model has %d output groups but no tree_info",
+				numGroup,
+			)
+		}
+		return numGroup, make([]int, numTrees), nil
+	}
+
+	if len(treeInfo) != numTrees {
+		return 0, nil, fmt.Errorf(
+			"tree_info has %d entries but the model has %d trees",
+			len(treeInfo),
+			numTrees,
+		)
+	}
+	for i, group := range treeInfo {
+		if group < 0 || group >= numGroup {
+			return 0, nil, fmt.Errorf(
+				"tree %d has output group %d; the model has %d output groups",
+				i,
+				group,
+				numGroup,
+			)
+		}
+	}
+
+	return numGroup, treeInfo, nil
+}
+
+// resolveNumParallelTree determines the number of trees of each output group
+// in each boosting round and checks that the model's trees make up whole
+// rounds. Models without num_parallel_tree have one.
+func resolveNumParallelTree(
+	param GBTreeModelParam,
+	numTrees,
+	numGroup int,
+) (int, error) {
+	numParallelTree := 1
+	if param.NumParallelTree != "" {
+		n, err := param.NumParallelTree.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("parsing num_parallel_tree: %w", err)
+		}
+		if n < 1 || n > math.MaxInt32 {
+			return 0, fmt.Errorf("invalid num_parallel_tree: %d", n)
+		}
+		numParallelTree = int(n)
+	}
+
+	if numTrees%(numParallelTree*numGroup) != 0 {
+		return 0, fmt.Errorf(
+			"model has %d trees, which is not a multiple of %d output groups "+
+				"times %d parallel trees",
+			numTrees,
+			numGroup,
+			numParallelTree,
+		)
+	}
+
+	return numParallelTree, nil
+}
+
+// resolveLayerEnd determines how many boosting rounds (layers) to use. An
+// explicit ntree limit counts trees per output group, as does
+// best_ntree_limit, which older XGBoost versions store; both are divided by
+// num_parallel_tree, rounding down, to get rounds, as XGBoost does. Newer
+// versions store best_iteration (0-based), so the number of rounds to use is
+// best_iteration + 1. When none of these is set there was no early stopping,
+// so all numLayers rounds are used. best_ntree_limit takes precedence over
+// best_iteration.
+//
+// As in XGBoost, a tree limit smaller than num_parallel_tree, which selects
+// no rounds, means all rounds. A negative value is an error, as is one that
+// selects more rounds than the model has unless the ClampNtreeLimit option is
+// set.
+func resolveLayerEnd(
+	o *Options,
+	attrs Attributes,
+	numLayers,
+	numParallelTree int,
+) (int, error) {
+	var (
+		layerEnd int
+		source   string
+		value    int64
+	)
 	switch {
+	case o.ntreeLimit != 0:
+		value = int64(o.ntreeLimit)
+		layerEnd = o.ntreeLimit / numParallelTree
+		source = "ntree limit"
 	case attrs.BestNtreeLimit != "":
 		n, err := attrs.BestNtreeLimit.Int64()
 		if err != nil {
 			return 0, fmt.Errorf("parsing best_ntree_limit: %w", err)
 		}
-		return int(n), nil
+		value = n
+		layerEnd = int(n / int64(numParallelTree))
+		source = "best_ntree_limit"
 	case attrs.BestIteration != "":
 		n, err := attrs.BestIteration.Int64()
 		if err != nil {
 			return 0, fmt.Errorf("parsing best_iteration: %w", err)
 		}
-		return int(n) + 1, nil
+		value = n
+		layerEnd = int(n) + 1
+		source = "best_iteration"
 	default:
-		return numTrees, nil
+		return numLayers, nil
 	}
+
+	if value < 0 {
+		return 0, fmt.Errorf("%s %d is negative", source, value)
+	}
+	source = fmt.Sprintf("%s %d", source, value)
+
+	// XGBoost treats an end of 0 as no limit. That happens when a tree limit
+	// is smaller than num_parallel_tree.
+	if layerEnd == 0 {
+		return numLayers, nil
+	}
+
+	if layerEnd > numLayers {
+		msg := fmt.Sprintf(
+			"%s selects %d boosting rounds but the model has %d",
+			source,
+			layerEnd,
+			numLayers,
+		)
+		if !o.clampNtreeLimit {
+			return 0, errors.New(msg)
+		}
+		if o.clampWarning != nil {
+			o.clampWarning(msg + "; using all of them")
+		}
+		layerEnd = numLayers
+	}
+
+	return layerEnd, nil
+}
+
+// resolve checks r against a model with numLayers boosting rounds and
+// returns its bounds, replacing an end of 0 with numLayers.
+func (r *iterationRange) resolve(numLayers int) (int, int, error) {
+	end := r.end
+	if end == 0 {
+		end = numLayers
+	}
+	if r.begin < 0 || r.begin >= end || end > numLayers {
+		return 0, 0, fmt.Errorf(
+			"invalid iteration range [%d, %d) for a model with %d boosting rounds",
+			r.begin,
+			r.end,
+			numLayers,
+		)
+	}
+	return r.begin, end, nil
+}
+
+// treeRange returns the range of trees that a prediction uses: those of the
+// boosting rounds selected by the per-call options, if any, and otherwise
+// those selected when the Predictor was created. Each round has
+// numParallelTree trees per output group.
+//
+// This is equivalent to LayerToTree() in xgboost (gbtree.h).
+func (p *Predictor) treeRange(o *PredictOptions) (int, int) {
+	layerBegin, layerEnd := p.layerBegin, p.layerEnd
+	if o.iterationRange != nil {
+		layerBegin, layerEnd = o.iterationRange.begin, o.iterationRange.end
+	}
+
+	treesPerLayer := p.numParallelTree * p.numGroup
+	return layerBegin * treesPerLayer, layerEnd * treesPerLayer
 }
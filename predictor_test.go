@@ -1,76 +1,401 @@
 package xgbshap
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path/filepath"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestResolveNtreeLimit(t *testing.T) {
-	const numTrees = 24
+func TestResolveLayerEnd(t *testing.T) {
+	const numLayers = 24
 
 	t.Run("best_ntree_limit is used directly", func(t *testing.T) {
-		n, err := resolveNtreeLimit(Attributes{BestNtreeLimit: "10"}, numTrees)
+		n, err := resolveLayerEnd(&Options{}, Attributes{BestNtreeLimit: "10"}, numLayers, 1)
 		require.NoError(t, err)
 		assert.Equal(t, 10, n)
 	})
 
 	t.Run("best_iteration is offset by one", func(t *testing.T) {
-		// best_iteration is 0-based, so 13 means 14 trees.
-		n, err := resolveNtreeLimit(Attributes{BestIteration: "13"}, numTrees)
+		// best_iteration is 0-based, so 13 means 14 rounds.
+		n, err := resolveLayerEnd(&Options{}, Attributes{BestIteration: "13"}, numLayers, 1)
 		require.NoError(t, err)
 		assert.Equal(t, 14, n)
 	})
 
-	t.Run("best_iteration of zero means one tree", func(t *testing.T) {
+	t.Run("best_iteration of zero means one round", func(t *testing.T) {
 		// The boundary value: best_iteration=0 (first iteration was best) is a
 		// legitimate XGBoost output and must resolve to 1, not 0.
-		n, err := resolveNtreeLimit(Attributes{BestIteration: "0"}, numTrees)
+		n, err := resolveLayerEnd(&Options{}, Attributes{BestIteration: "0"}, numLayers, 1)
 		require.NoError(t, err)
 		assert.Equal(t, 1, n)
 	})
 
-	t.Run("neither attribute uses all trees", func(t *testing.T) {
-		n, err := resolveNtreeLimit(Attributes{}, numTrees)
+	t.Run("neither attribute uses all rounds", func(t *testing.T) {
+		n, err := resolveLayerEnd(&Options{}, Attributes{}, numLayers, 1)
 		require.NoError(t, err)
-		assert.Equal(t, numTrees, n)
+		assert.Equal(t, numLayers, n)
 	})
 
 	t.Run("best_ntree_limit takes precedence over best_iteration", func(t *testing.T) {
-		n, err := resolveNtreeLimit(
+		n, err := resolveLayerEnd(
+			&Options{},
 			Attributes{BestNtreeLimit: "10", BestIteration: "13"},
-			numTrees,
+			numLayers,
+			1,
 		)
 		require.NoError(t, err)
 		assert.Equal(t, 10, n)
 	})
 
+	t.Run("explicit limit takes precedence over attributes", func(t *testing.T) {
+		n, err := resolveLayerEnd(
+			&Options{ntreeLimit: 5},
+			Attributes{BestNtreeLimit: "10", BestIteration: "13"},
+			numLayers,
+			1,
+		)
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+	})
+
+	t.Run("tree limits count parallel trees", func(t *testing.T) {
+		// XGBoost sets best_ntree_limit to (best_iteration + 1) *
+		// num_parallel_tree.
+		n, err := resolveLayerEnd(&Options{}, Attributes{BestNtreeLimit: "6"}, numLayers, 3)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		n, err = resolveLayerEnd(&Options{ntreeLimit: 9}, Attributes{}, numLayers, 3)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+	})
+
+	t.Run("best_iteration counts rounds with parallel trees", func(t *testing.T) {
+		n, err := resolveLayerEnd(&Options{}, Attributes{BestIteration: "1"}, numLayers, 3)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+	})
+
+	t.Run("tree limits round down to whole rounds", func(t *testing.T) {
+		// XGBoost uses ntree_limit // num_parallel_tree for both sources.
+		n, err := resolveLayerEnd(&Options{ntreeLimit: 5}, Attributes{}, numLayers, 3)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		n, err = resolveLayerEnd(&Options{}, Attributes{BestNtreeLimit: "5"}, numLayers, 3)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+	})
+
+	t.Run("tree limits below num_parallel_tree use all rounds", func(t *testing.T) {
+		// ntree_limit // num_parallel_tree is 0, which XGBoost treats as no
+		// limit.
+		n, err := resolveLayerEnd(&Options{ntreeLimit: 2}, Attributes{}, numLayers, 3)
+		require.NoError(t, err)
+		assert.Equal(t, numLayers, n)
+
+		n, err = resolveLayerEnd(&Options{}, Attributes{BestNtreeLimit: "2"}, numLayers, 3)
+		require.NoError(t, err)
+		assert.Equal(t, numLayers, n)
+
+		n, err = resolveLayerEnd(&Options{}, Attributes{BestNtreeLimit: "0"}, numLayers, 1)
+		require.NoError(t, err)
+		assert.Equal(t, numLayers, n)
+	})
+
+	t.Run("limits beyond the model error", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			o       Options
+			attrs   Attributes
+			wantErr string
+		}{
+			{
+				name:    "ntree limit",
+				o:       Options{ntreeLimit: 25},
+				wantErr: "ntree limit 25 selects 25 boosting rounds but the model has 24",
+			},
+			{
+				name:    "best_ntree_limit",
+				attrs:   Attributes{BestNtreeLimit: "30"},
+				wantErr: "best_ntree_limit 30 selects 30 boosting rounds but the model has 24",
+			},
+			{
+				name:    "best_iteration",
+				attrs:   Attributes{BestIteration: "24"},
+				wantErr: "best_iteration 24 selects 25 boosting rounds but the model has 24",
+			},
+			{
+				name:    "negative ntree limit",
+				o:       Options{ntreeLimit: -1},
+				wantErr: "ntree limit -1 is negative",
+			},
+			{
+				name:    "negative best_ntree_limit",
+				attrs:   Attributes{BestNtreeLimit: "-3"},
+				wantErr: "best_ntree_limit -3 is negative",
+			},
+			{
+				name:    "negative best_iteration",
+				attrs:   Attributes{BestIteration: "-2"},
+				wantErr: "best_iteration -2 is negative",
+			},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				_, err := resolveLayerEnd(&test.o, test.attrs, numLayers, 1)
+				require.EqualError(t, err, test.wantErr)
+			})
+		}
+	})
+
+	t.Run("clamping", func(t *testing.T) {
+		var warnings []string
+		o := &Options{ntreeLimit: 30}
+		ClampNtreeLimit(func(msg string) { warnings = append(warnings, msg) })(o)
+
+		n, err := resolveLayerEnd(o, Attributes{}, numLayers, 1)
+		require.NoError(t, err)
+		assert.Equal(t, numLayers, n)
+		assert.Equal(
+			t,
+			[]string{
+				"ntree limit 30 selects 30 boosting rounds but the model has " +
+					"24; using all of them",
+			},
+			warnings,
+		)
+
+		// A nil warning function clamps silently.
+		n, err = resolveLayerEnd(
+			&Options{clampNtreeLimit: true},
+			Attributes{BestNtreeLimit: "30"},
+			numLayers,
+			1,
+		)
+		require.NoError(t, err)
+		assert.Equal(t, numLayers, n)
+
+		// Limits that select no rounds are not clamped.
+		_, err = resolveLayerEnd(
+			&Options{ntreeLimit: -1, clampNtreeLimit: true},
+			Attributes{},
+			numLayers,
+			1,
+		)
+		require.Error(t, err)
+	})
+
 	t.Run("non-numeric best_ntree_limit errors", func(t *testing.T) {
-		_, err := resolveNtreeLimit(Attributes{BestNtreeLimit: "bogus"}, numTrees)
+		_, err := resolveLayerEnd(&Options{}, Attributes{BestNtreeLimit: "bogus"}, numLayers, 1)
 		require.ErrorContains(t, err, "best_ntree_limit")
 	})
 
 	t.Run("non-numeric best_iteration errors", func(t *testing.T) {
-		_, err := resolveNtreeLimit(Attributes{BestIteration: "bogus"}, numTrees)
+		_, err := resolveLayerEnd(&Options{}, Attributes{BestIteration: "bogus"}, numLayers, 1)
 		require.ErrorContains(t, err, "best_iteration")
 	})
 }
 
+func TestIterationRangeResolve(t *testing.T) {
+	const numLayers = 4
+
+	tests := []struct {
+		begin     int
+		end       int
+		wantBegin int
+		wantEnd   int
+		wantErr   bool
+	}{
+		{begin: 0, end: 4, wantBegin: 0, wantEnd: 4},
+		{begin: 1, end: 3, wantBegin: 1, wantEnd: 3},
+		{begin: 3, end: 4, wantBegin: 3, wantEnd: 4},
+		{begin: 0, end: 0, wantBegin: 0, wantEnd: 4},
+		{begin: 2, end: 0, wantBegin: 2, wantEnd: 4},
+		{begin: -1, end: 2, wantErr: true},
+		{begin: 2, end: 2, wantErr: true},
+		{begin: 3, end: 1, wantErr: true},
+		{begin: 0, end: 5, wantErr: true},
+		{begin: 4, end: 0, wantErr: true},
+		{begin: 0, end: -1, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("[%d, %d)", test.begin, test.end), func(t *testing.T) {
+			r := iterationRange{begin: test.begin, end: test.end}
+			begin, end, err := r.resolve(numLayers)
+			if test.wantErr {
+				require.ErrorContains(t, err, "invalid iteration range")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.wantBegin, begin)
+			assert.Equal(t, test.wantEnd, end)
+		})
+	}
+}
+
+func TestIterationRange(t *testing.T) {
+	// The model has two rounds of two parallel trees. See
+	// TestPredictContributionsRandomForest.
+	const modelFile = "testdata/rf-regressor/model.json"
+	features := []*float32{toPtr(1), toPtr(0)}
+
+	// The second round alone: f0 = 20 - 15, f1 = 10 - 15, and the bias is
+	// 0.5 + 15 + 15.
+	secondRound := []float32{5, -5, 30.5}
+
+	t.Run("option", func(t *testing.T) {
+		p, err := NewPredictor(modelFile, IterationRange(1, 2))
+		require.NoError(t, err)
+
+		contributions, err := p.PredictContributions(features)
+		require.NoError(t, err)
+		assert.Equal(t, secondRound, contributions)
+
+		margin, err := p.PredictMargin(features)
+		require.NoError(t, err)
+		assert.Equal(t, []float32{30.5}, margin)
+	})
+
+	t.Run("per-call override", func(t *testing.T) {
+		// The model's best_iteration selects only the first round, which the
+		// per-call range overrides.
+		p, err := NewPredictor(modelFile)
+		require.NoError(t, err)
+
+		contributions, err := p.PredictContributions(features, Iterations(1, 0))
+		require.NoError(t, err)
+		assert.Equal(t, secondRound, contributions)
+
+		margin, err := p.PredictMargin(features, Iterations(1, 2))
+		require.NoError(t, err)
+		assert.Equal(t, []float32{30.5}, margin)
+
+		// Without the option, the Predictor's range is used again.
+		contributions, err = p.PredictContributions(features)
+		require.NoError(t, err)
+		assert.Equal(t, []float32{1, -2, 2.5}, contributions)
+
+		_, err = p.PredictContributions(features, Iterations(0, 3))
+		require.EqualError(
+			t,
+			err,
+			"invalid iteration range [0, 3) for a model with 2 boosting rounds",
+		)
+	})
+
+	t.Run("invalid option", func(t *testing.T) {
+		_, err := NewPredictor(modelFile, IterationRange(2, 1))
+		require.ErrorContains(t, err, "invalid iteration range [2, 1)")
+	})
+
+	t.Run("combined with NtreeLimit", func(t *testing.T) {
+		_, err := NewPredictor(modelFile, IterationRange(0, 1), NtreeLimit(2))
+		require.EqualError(
+			t,
+			err,
+			"IterationRange and NtreeLimit may not both be set",
+		)
+	})
+
+	t.Run("ranges add up to the whole model", func(t *testing.T) {
+		// Contributions are sums over trees, so the contributions of two
+		// adjacent ranges add up to those of their union, except that each
+		// includes the base score in its bias.
+		p, err := NewPredictor("testdata/roundtrip/model.json")
+		require.NoError(t, err)
+
+		allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+		require.NoError(t, err)
+
+		for row, features := range allFeatures[:10] {
+			all, err := p.PredictContributions(features, Iterations(0, 0))
+			require.NoError(t, err)
+			early, err := p.PredictContributions(features, Iterations(0, 10))
+			require.NoError(t, err)
+			late, err := p.PredictContributions(features, Iterations(10, 0))
+			require.NoError(t, err)
+
+			bias := len(all) - 1
+			for i := range all {
+				sum := early[i] + late[i]
+				if i == bias {
+					sum -= p.baseMargin[0]
+				}
+				assert.InDelta(t, all[i], sum, 1e-5, "row %d, column %d", row, i)
+			}
+		}
+	})
+}
+
+func TestResolveNumParallelTree(t *testing.T) {
+	t.Run("absent means one", func(t *testing.T) {
+		n, err := resolveNumParallelTree(GBTreeModelParam{}, 6, 3)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("value is used", func(t *testing.T) {
+		n, err := resolveNumParallelTree(
+			GBTreeModelParam{NumParallelTree: "2"},
+			12,
+			3,
+		)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+	})
+
+	t.Run("partial round", func(t *testing.T) {
+		_, err := resolveNumParallelTree(
+			GBTreeModelParam{NumParallelTree: "2"},
+			9,
+			3,
+		)
+		require.EqualError(
+			t,
+			err,
+			"model has 9 trees, which is not a multiple of 3 output groups "+
+				"times 2 parallel trees",
+		)
+	})
+
+	for _, value := range []json.Number{"0", "-1", "x"} {
+		t.Run("invalid "+string(value), func(t *testing.T) {
+			_, err := resolveNumParallelTree(
+				GBTreeModelParam{NumParallelTree: value},
+				6,
+				1,
+			)
+			require.ErrorContains(t, err, "num_parallel_tree")
+		})
+	}
+}
+
 func TestNewPredictorResolvesNtreeLimit(t *testing.T) {
 	// The roundtrip model stores best_iteration=13, so the predictor should use
-	// 14 trees. This pins the end-to-end wiring of resolveNtreeLimit into
+	// 14 trees. This pins the end-to-end wiring of resolveLayerEnd into
 	// NewPredictor, including the best_iteration+1 offset.
 	p, err := NewPredictor("testdata/roundtrip/model.json")
 	require.NoError(t, err)
-	assert.Equal(t, 14, p.ntreeLimit)
+	assert.Equal(t, 14, p.layerEnd)
 }
 
 func TestNewPredictorExplicitNtreeLimitWins(t *testing.T) {
 	// An explicit non-zero NtreeLimit bypasses attribute-based resolution.
 	p, err := NewPredictor("testdata/roundtrip/model.json", NtreeLimit(5))
 	require.NoError(t, err)
-	assert.Equal(t, 5, p.ntreeLimit)
+	assert.Equal(t, 5, p.layerEnd)
 }
 
 func TestNewPredictorNtreeLimitZeroFallsThrough(t *testing.T) {
@@ -79,5 +404,363 @@ func TestNewPredictorNtreeLimitZeroFallsThrough(t *testing.T) {
 	// It does not mean "use zero trees".
 	p, err := NewPredictor("testdata/roundtrip/model.json", NtreeLimit(0))
 	require.NoError(t, err)
-	assert.Equal(t, 14, p.ntreeLimit)
+	assert.Equal(t, 14, p.layerEnd)
+}
+
+func TestNewPredictorNtreeLimitBeyondModel(t *testing.T) {
+	// The small model has 33 trees but best_ntree_limit is 28. Predictions
+	// used to index past the end of the trees at call time; now NewPredictor
+	// rejects the limit.
+	_, err := NewPredictor("testdata/small-model/model.json", NtreeLimit(34))
+	require.EqualError(
+		t,
+		err,
+		"ntree limit 34 selects 34 boosting rounds but the model has 33",
+	)
+
+	p, err := NewPredictor(
+		"testdata/small-model/model.json",
+		NtreeLimit(34),
+		ClampNtreeLimit(nil),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 33, p.NtreeLimit())
+
+	_, err = p.PredictContributions(make([]*float32, 30))
+	require.NoError(t, err)
+}
+
+func TestPredictorAccessors(t *testing.T) {
+	p, err := NewPredictor("testdata/small-model/model.json")
+	require.NoError(t, err)
+	assert.Equal(t, 33, p.NumTrees())
+	assert.Equal(t, 28, p.NtreeLimit())
+
+	// The limit counts trees per output group.
+	p, err = NewPredictor("testdata/multiclass/model.json")
+	require.NoError(t, err)
+	assert.Equal(t, 6, p.NumTrees())
+	assert.Equal(t, 2, p.NtreeLimit())
+
+	// It also counts parallel trees.
+	p, err = NewPredictor("testdata/rf-regressor/model.json")
+	require.NoError(t, err)
+	assert.Equal(t, 4, p.NumTrees())
+	assert.Equal(t, 2, p.NtreeLimit())
+
+	p, err = NewPredictor(
+		"testdata/rf-regressor/model.json",
+		IterationRange(0, 2),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 4, p.NtreeLimit())
+}
+
+func TestNewPredictorFromReaderAndBytes(t *testing.T) {
+	const modelFile = "testdata/small-model/model.json"
+
+	fromFile, err := NewPredictor(modelFile)
+	require.NoError(t, err)
+
+	buf := readFile(t, modelFile)
+
+	fromBytes, err := NewPredictorFromBytes(buf)
+	require.NoError(t, err)
+
+	fromReader, err := NewPredictorFromReader(bytes.NewReader(buf))
+	require.NoError(t, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/small-model/features.csv")
+	require.NoError(t, err)
+
+	for _, features := range allFeatures {
+		want, err := fromFile.PredictContributions(features)
+		require.NoError(t, err)
+
+		got, err := fromBytes.PredictContributions(features)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+
+		got, err = fromReader.PredictContributions(features)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestNewPredictorFromReaderError(t *testing.T) {
+	_, err := NewPredictorFromReader(iotest.ErrReader(errors.New("boom")))
+	require.ErrorContains(t, err, "boom")
+}
+
+func TestNewPredictorMissingFile(t *testing.T) {
+	_, err := NewPredictor("testdata/does-not-exist/model.json")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestResolveTreeGroups(t *testing.T) {
+	model := func(numClass, numTarget string, treeInfo []int) *XGBModel {
+		var xm XGBModel
+		xm.Learner.LearnerModelParam.NumClass = json.Number(numClass)
+		xm.Learner.LearnerModelParam.NumTarget = json.Number(numTarget)
+		xm.Learner.GradientBooster.Model.TreeInfo = treeInfo
+		return &xm
+	}
+
+	t.Run("missing tree_info with one group", func(t *testing.T) {
+		numGroup, groups, err := resolveTreeGroups(model("", "", nil), 3)
+		require.NoError(t, err)
+		assert.Equal(t, 1, numGroup)
+		assert.Equal(t, []int{0, 0, 0}, groups)
+	})
+
+	t.Run("binary model", func(t *testing.T) {
+		numGroup, groups, err := resolveTreeGroups(
+			model("0", "1", []int{0, 0}),
+			2,
+		)
+		require.NoError(t, err)
+		assert.Equal(t, 1, numGroup)
+		assert.Equal(t, []int{0, 0}, groups)
+	})
+
+	t.Run("multi-class model", func(t *testing.T) {
+		numGroup, groups, err := resolveTreeGroups(
+			model("3", "1", []int{0, 1, 2}),
+			3,
+		)
+		require.NoError(t, err)
+		assert.Equal(t, 3, numGroup)
+		assert.Equal(t, []int{0, 1, 2}, groups)
+	})
+
+	t.Run("multi-target model", func(t *testing.T) {
+		numGroup, _, err := resolveTreeGroups(model("0", "2", []int{0, 1}), 2)
+		require.NoError(t, err)
+		assert.Equal(t, 2, numGroup)
+	})
+
+	tests := []struct {
+		name     string
+		xm       *XGBModel
+		numTrees int
+		err      string
+	}{
+		{
+			name:     "multiple groups without tree_info",
+			xm:       model("3", "1", nil),
+			numTrees: 3,
+			err:      "no tree_info",
+		},
+		{
+			name:     "tree_info length mismatch",
+			xm:       model("3", "1", []int{0, 1}),
+			numTrees: 3,
+			err:      "tree_info has 2 entries",
+		},
+		{
+			name:     "group out of range",
+			xm:       model("3", "1", []int{0, 1, 3}),
+			numTrees: 3,
+			err:      "output group 3",
+		},
+		{
+			name:     "negative group",
+			xm:       model("3", "1", []int{0, -1, 2}),
+			numTrees: 3,
+			err:      "output group -1",
+		},
+		{
+			name:     "non-numeric num_class",
+			xm:       model("bogus", "1", nil),
+			numTrees: 1,
+			err:      "num_class",
+		},
+		{
+			name:     "negative num_target",
+			xm:       model("0", "-1", nil),
+			numTrees: 1,
+			err:      "num_target",
+		},
+		{
+			name:     "num_class too large",
+			xm:       model("4294967296", "1", []int{0}),
+			numTrees: 1,
+			err:      "invalid num_class: 4294967296",
+		},
+		{
+			name:     "more groups than trees",
+			xm:       model("1000000", "1", []int{0, 1}),
+			numTrees: 2,
+			err:      "model has 1000000 output groups but only 2 trees",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := resolveTreeGroups(test.xm, test.numTrees)
+			require.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestResolveNumFeature(t *testing.T) {
+	// A root splitting on feature 2 with two leaves.
+	trees := []*Tree{{Nodes: make([]Node, 3), NumNodes: 3}}
+	trees[0].Nodes[0] = Node{
+		Left:  &trees[0].Nodes[1],
+		Right: &trees[0].Nodes[2],
+		Data:  NodeData{SplitIndex: 2},
+	}
+
+	t.Run("num_feature is used", func(t *testing.T) {
+		n, err := resolveNumFeature(LearnerModelParam{NumFeature: "5"}, trees)
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+	})
+
+	t.Run("absent num_feature uses largest split index", func(t *testing.T) {
+		n, err := resolveNumFeature(LearnerModelParam{}, trees)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+	})
+
+	t.Run("split index out of range", func(t *testing.T) {
+		_, err := resolveNumFeature(LearnerModelParam{NumFeature: "2"}, trees)
+		require.ErrorContains(t, err, "splits on feature 2")
+	})
+
+	t.Run("invalid split index", func(t *testing.T) {
+		for _, splitIndex := range []int{-1, math.MaxInt32, math.MaxInt} {
+			trees[0].Nodes[0].Data.SplitIndex = splitIndex
+			_, err := resolveNumFeature(LearnerModelParam{}, trees)
+			require.EqualError(
+				t,
+				err,
+				fmt.Sprintf(
+					"tree 0: node 0 splits on invalid feature %d",
+					splitIndex,
+				),
+			)
+		}
+		trees[0].Nodes[0].Data.SplitIndex = 2
+	})
+
+	t.Run("non-numeric num_feature", func(t *testing.T) {
+		_, err := resolveNumFeature(LearnerModelParam{NumFeature: "x"}, trees)
+		require.ErrorContains(t, err, "num_feature")
+	})
+
+	t.Run("negative num_feature", func(t *testing.T) {
+		_, err := resolveNumFeature(LearnerModelParam{NumFeature: "-1"}, trees)
+		require.ErrorContains(t, err, "num_feature")
+	})
+}
+
+func TestFeatureCountError(t *testing.T) {
+	p, err := NewPredictor("testdata/small-model/model.json")
+	require.NoError(t, err)
+
+	for _, n := range []int{0, 29, 31} {
+		features := make([]*float32, n)
+
+		calls := map[string]func() error{
+			"PredictContributions": func() error {
+				_, err := p.PredictContributions(features)
+				return err
+			},
+			"PredictContributionsMulticlass": func() error {
+				_, err := p.PredictContributionsMulticlass(features)
+				return err
+			},
+			"PredictContributionsInto": func() error {
+				return p.PredictContributionsInto(
+					make([]float32, n+1),
+					features,
+					nil,
+				)
+			},
+			"PredictApproxContributions": func() error {
+				_, err := p.PredictApproxContributions(features)
+				return err
+			},
+			"PredictInteractions": func() error {
+				_, err := p.PredictInteractions(features)
+				return err
+			},
+			"PredictContributionsBatch": func() error {
+				_, err := p.PredictContributionsBatch(
+					t.Context(),
+					[][]*float32{make([]*float32, 30), features},
+				)
+				return err
+			},
+			"Predict": func() error {
+				_, err := p.Predict(features)
+				return err
+			},
+		}
+		for name, call := range calls {
+			t.Run(fmt.Sprintf("%s with %d features", name, n), func(t *testing.T) {
+				var fce *FeatureCountError
+				require.ErrorAs(t, call(), &fce)
+				assert.Equal(t, FeatureCountError{Got: n, Want: 30}, *fce)
+			})
+		}
+	}
+}
+
+// maxFuzzFeatures bounds the number of features FuzzNewPredictorFromBytes
+// predicts with. Callers allocate a slice of num_feature features, so a
+// model with a huge num_feature is not a problem of the parser, and
+// PredictInteractions takes time and memory quadratic in num_feature, which
+// would slow fuzzing to a crawl.
+const maxFuzzFeatures = 64
+
+// FuzzNewPredictorFromBytes checks that models built from arbitrary bytes
+// either fail to load with an error or make predictions without panicking.
+// The inputs in testdata/fuzz/FuzzNewPredictorFromBytes are ones that once
+// panicked or exhausted memory.
+func FuzzNewPredictorFromBytes(f *testing.F) {
+	models, err := filepath.Glob("testdata/*/model.*")
+	require.NoError(f, err)
+	for _, path := range models {
+		f.Add(readFile(f, path), float32(0.5))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, value float32) {
+		p, err := NewPredictorFromBytes(data)
+		if err != nil {
+			return
+		}
+		if p.numFeature > maxFuzzFeatures {
+			return
+		}
+
+		present := make([]*float32, p.numFeature)
+		for i := range present {
+			present[i] = &value
+		}
+
+		for _, features := range [][]*float32{
+			make([]*float32, p.numFeature),
+			present,
+		} {
+			_, err := p.PredictMargin(features)
+			require.NoError(t, err)
+
+			if p.numGroup != 1 {
+				_, err = p.PredictContributionsMulticlass(features)
+				require.NoError(t, err)
+				continue
+			}
+
+			_, err = p.PredictContributions(features)
+			require.NoError(t, err)
+
+			_, err = p.PredictApproxContributions(features)
+			require.NoError(t, err)
+
+			_, err = p.PredictInteractions(features)
+			require.NoError(t, err)
+		}
+	})
 }
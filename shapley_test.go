@@ -0,0 +1,362 @@
+package xgbshap
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The tests in this file check TreeSHAP against exact Shapley values, found
+// by enumerating every coalition of features, on randomly generated models.
+// This catches errors in the path bookkeeping of extendPath, unwindPath, and
+// unwoundPathSum without needing XGBoost to generate expected output.
+
+func TestPredictContributionsMatchesBruteForce(t *testing.T) {
+	for trial := range 200 {
+		rng := rand.New(rand.NewPCG(1, uint64(trial))) //nolint:gosec // Test data need not be secure.
+		m := newRandomModel(t, rng)
+
+		for row := range 5 {
+			features := m.randomRow(rng)
+
+			got, err := m.p.PredictContributions(features)
+			require.NoError(t, err)
+
+			want := make([]float64, m.numFeature+1)
+			for _, tree := range m.p.trees {
+				phi := bruteForceShapley(
+					m.numFeature,
+					-1,
+					func(coalition uint64) float64 {
+						return conditionalExpectation(tree, 0, features, coalition)
+					},
+				)
+				for i, v := range phi {
+					want[i] += v
+				}
+				want[m.numFeature] += conditionalExpectation(tree, 0, features, 0)
+			}
+			want[m.numFeature] += float64(m.p.baseMargin[0])
+
+			assertContributionsEqual(
+				t,
+				want,
+				got,
+				fmt.Sprintf("trial %d, row %d", trial, row),
+			)
+		}
+	}
+}
+
+func TestPredictConditionalContributionsMatchesBruteForce(t *testing.T) {
+	for trial := range 100 {
+		rng := rand.New(rand.NewPCG(2, uint64(trial))) //nolint:gosec // Test data need not be secure.
+		m := newRandomModel(t, rng)
+
+		features := m.randomRow(rng)
+		conditionFeature := rng.IntN(m.numFeature)
+		conditionBit := uint64(1) << conditionFeature
+
+		for _, condition := range []int{1, -1} {
+			got, err := m.p.PredictConditionalContributions(
+				features,
+				conditionFeature,
+				condition,
+			)
+			require.NoError(t, err)
+
+			// With the condition feature present, every coalition includes
+			// it; with it absent, none do. Either way, it is not a player.
+			want := make([]float64, m.numFeature+1)
+			for _, tree := range m.p.trees {
+				phi := bruteForceShapley(
+					m.numFeature,
+					conditionFeature,
+					func(coalition uint64) float64 {
+						if condition == 1 {
+							coalition |= conditionBit
+						}
+						return conditionalExpectation(tree, 0, features, coalition)
+					},
+				)
+				for i, v := range phi {
+					want[i] += v
+				}
+			}
+			want[m.numFeature] = float64(m.p.baseMargin[0])
+
+			assertContributionsEqual(
+				t,
+				want,
+				got,
+				fmt.Sprintf(
+					"trial %d, condition %d on feature %d",
+					trial,
+					condition,
+					conditionFeature,
+				),
+			)
+		}
+	}
+}
+
+// bruteForceShapley returns the Shapley value of each of numFeature features
+// for the game with the given value function, in which coalitions are bit
+// sets of features. If exclude is a feature index, that feature is not a
+// player and its value is zero.
+func bruteForceShapley(
+	numFeature,
+	exclude int,
+	value func(coalition uint64) float64,
+) []float64 {
+	var players []int
+	for i := range numFeature {
+		if i != exclude {
+			players = append(players, i)
+		}
+	}
+	n := len(players)
+
+	phi := make([]float64, numFeature)
+	for subset := range uint64(1) << n {
+		var coalition uint64
+		size := 0
+		for k, player := range players {
+			if subset&(1<<k) != 0 {
+				coalition |= 1 << player
+				size++
+			}
+		}
+
+		v := value(coalition)
+		for _, player := range players {
+			if coalition&(1<<player) != 0 {
+				continue
+			}
+			phi[player] += shapleyWeight(size, n-size-1) *
+				(value(coalition|1<<player) - v)
+		}
+	}
+
+	return phi
+}
+
+// conditionalExpectation returns the expected output of the subtree at
+// nodeIndex given the values of the features in coalition. Splits on those
+// features follow features; splits on other features average the children
+// weighted by their sum of hessians. This is the expectation TreeSHAP uses,
+// and with an empty coalition it is the mean value fillNodeMeanValues
+// computes.
+func conditionalExpectation(
+	tree *Tree,
+	nodeIndex int,
+	features []*float32,
+	coalition uint64,
+) float64 {
+	node := &tree.Nodes[nodeIndex]
+	if node.IsLeaf() {
+		return float64(node.LeafValue())
+	}
+
+	feature := node.Data.SplitIndex
+	if coalition&(1<<feature) != 0 {
+		value := features[feature]
+		next := getNextNode(true, node, nodeIndex, value, isMissingValue(value))
+		return conditionalExpectation(tree, next, features, coalition)
+	}
+
+	left, right := node.Left, node.Right
+	if node.Data.SumHessian == 0 {
+		// As in childFractions, the children are weighted evenly.
+		return (conditionalExpectation(tree, left.Data.ID, features, coalition) +
+			conditionalExpectation(tree, right.Data.ID, features, coalition)) / 2
+	}
+	return (conditionalExpectation(tree, left.Data.ID, features, coalition)*
+		float64(left.Data.SumHessian) +
+		conditionalExpectation(tree, right.Data.ID, features, coalition)*
+			float64(right.Data.SumHessian)) /
+		float64(node.Data.SumHessian)
+}
+
+// assertContributionsEqual checks that got matches the exact values in want
+// to within float32 rounding error.
+func assertContributionsEqual(
+	t *testing.T,
+	want []float64,
+	got []float32,
+	msg string,
+) {
+	t.Helper()
+
+	require.Len(t, got, len(want), msg)
+	for i := range want {
+		assert.InDelta(
+			t,
+			want[i],
+			got[i],
+			1e-4*max(1, math.Abs(want[i])),
+			"%s, column %d", msg, i,
+		)
+	}
+}
+
+// numRandomCategories is the number of categories of the categorical
+// features of random models.
+const numRandomCategories = 4
+
+type randomModel struct {
+	p              *Predictor
+	numFeature     int
+	numCategorical int
+}
+
+// newRandomModel returns a Predictor for a random model with up to 6
+// features, the first numCategorical of which are categorical, and up to 4
+// trees of depth up to 5. Features may be split on more than once on a path,
+// which exercises unwinding a feature's earlier split.
+func newRandomModel(t *testing.T, rng *rand.Rand) *randomModel {
+	t.Helper()
+
+	numFeature := 1 + rng.IntN(6)
+	numCategorical := rng.IntN(numFeature + 1)
+	numTrees := 1 + rng.IntN(4)
+
+	trees := make([]XGBTree, numTrees)
+	for i := range trees {
+		trees[i] = randomTree(rng, numFeature, numCategorical, 1+rng.IntN(5))
+	}
+
+	featureTypes := make([]string, numFeature)
+	for i := range featureTypes {
+		featureTypes[i] = "float"
+		if i < numCategorical {
+			featureTypes[i] = "c"
+		}
+	}
+
+	model := map[string]any{
+		"learner": map[string]any{
+			"feature_types": featureTypes,
+			"gradient_booster": map[string]any{
+				"name": "gbtree",
+				"model": map[string]any{
+					"gbtree_model_param": map[string]any{
+						"num_parallel_tree": "1",
+					},
+					"tree_info": make([]int, numTrees),
+					"trees":     trees,
+				},
+			},
+			"learner_model_param": map[string]any{
+				"base_score":  strconv.FormatFloat(rng.NormFloat64(), 'E', -1, 32),
+				"num_class":   "0",
+				"num_feature": strconv.Itoa(numFeature),
+				"num_target":  "1",
+			},
+			"objective": map[string]any{"name": "reg:squarederror"},
+		},
+	}
+
+	buf, err := json.Marshal(model)
+	require.NoError(t, err)
+
+	p, err := NewPredictorFromBytes(buf)
+	require.NoError(t, err)
+
+	return &randomModel{
+		p:              p,
+		numFeature:     numFeature,
+		numCategorical: numCategorical,
+	}
+}
+
+// randomRow returns random features for m. Some are missing, either as nil
+// or as NaN.
+func (m *randomModel) randomRow(rng *rand.Rand) []*float32 {
+	features := make([]*float32, m.numFeature)
+	for i := range features {
+		var v float32
+		switch {
+		case rng.IntN(5) == 0:
+			continue
+		case rng.IntN(10) == 0:
+			v = float32(math.NaN())
+		case i < m.numCategorical:
+			v = float32(rng.IntN(numRandomCategories + 1))
+		default:
+			v = float32(rng.NormFloat64())
+		}
+		features[i] = &v
+	}
+	return features
+}
+
+// randomTree returns a random tree of at most the given depth. Splits on the
+// first numCategorical features are categorical. Each node's sum of hessians
+// is the sum of its children's, as in a trained tree.
+func randomTree(
+	rng *rand.Rand,
+	numFeature,
+	numCategorical,
+	maxDepth int,
+) XGBTree {
+	var xt XGBTree
+
+	var addNode func(depth int) (int, float32)
+	addNode = func(depth int) (int, float32) {
+		id := len(xt.LeftChildren)
+		xt.BaseWeights = append(xt.BaseWeights, float32(rng.NormFloat64()))
+		xt.LeftChildren = append(xt.LeftChildren, -1)
+		xt.RightChildren = append(xt.RightChildren, -1)
+		xt.SplitIndices = append(xt.SplitIndices, 0)
+		xt.SplitConditions = append(xt.SplitConditions, 0)
+		xt.DefaultLeft = append(xt.DefaultLeft, rng.IntN(2))
+		xt.SplitType = append(xt.SplitType, 0)
+		xt.SumHessian = append(xt.SumHessian, 0)
+
+		if depth == maxDepth || (depth > 0 && rng.IntN(4) == 0) {
+			xt.SplitConditions[id] = xgbFloat(xt.BaseWeights[id])
+			xt.SumHessian[id] = 0.1 + 10*rng.Float32()
+			return id, xt.SumHessian[id]
+		}
+
+		feature := rng.IntN(numFeature)
+		xt.SplitIndices[id] = feature
+		if feature < numCategorical {
+			xt.SplitType[id] = 1
+			xt.CategoriesNodes = append(xt.CategoriesNodes, id)
+			xt.CategoriesSegments = append(
+				xt.CategoriesSegments,
+				len(xt.Categories),
+			)
+			size := 0
+			for category := range numRandomCategories {
+				if rng.IntN(2) == 0 {
+					xt.Categories = append(xt.Categories, category)
+					size++
+				}
+			}
+			xt.CategoriesSizes = append(xt.CategoriesSizes, size)
+		} else {
+			xt.SplitConditions[id] = xgbFloat(rng.NormFloat64())
+		}
+
+		left, leftHessian := addNode(depth + 1)
+		right, rightHessian := addNode(depth + 1)
+		xt.LeftChildren[id] = left
+		xt.RightChildren[id] = right
+		xt.SumHessian[id] = leftHessian + rightHessian
+		return id, xt.SumHessian[id]
+	}
+
+	addNode(0)
+	xt.TreeParam.NumNodes = json.Number(strconv.Itoa(len(xt.LeftChildren)))
+
+	return xt
+}
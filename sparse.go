@@ -0,0 +1,233 @@
+package xgbshap
+
+import (
+	"context"
+	"fmt"
+)
+
+// SparseVector holds the present features of a row. Indices holds the indexes
+// of the present features in strictly increasing order and Values holds their
+// values. Features that are not listed are missing.
+type SparseVector struct {
+	Indices []int
+	Values  []float32
+}
+
+// CSRMatrix holds rows of features in compressed sparse row format, the
+// layout of a SciPy csr_matrix and of the CSR input to an XGBoost DMatrix.
+// Row i's present features are Indices[RowPtr[i]:RowPtr[i+1]], in strictly
+// increasing order, with the corresponding Values. RowPtr therefore has one
+// more element than there are rows, and its first element is 0.
+type CSRMatrix struct {
+	RowPtr  []int
+	Indices []int
+	Values  []float32
+}
+
+// NumRows returns the number of rows in m.
+func (m *CSRMatrix) NumRows() int {
+	return max(len(m.RowPtr)-1, 0)
+}
+
+// Row returns row i of m. It does not copy the row's data.
+func (m *CSRMatrix) Row(i int) SparseVector {
+	start, end := m.RowPtr[i], m.RowPtr[i+1]
+	return SparseVector{
+		Indices: m.Indices[start:end],
+		Values:  m.Values[start:end],
+	}
+}
+
+// validate checks that m is well formed. The rows' indices are checked when
+// each row is used.
+func (m *CSRMatrix) validate() error {
+	if len(m.Indices) != len(m.Values) {
+		return fmt.Errorf(
+			"matrix has %d indices but %d values",
+			len(m.Indices),
+			len(m.Values),
+		)
+	}
+
+	if len(m.RowPtr) == 0 {
+		if len(m.Indices) != 0 {
+			return fmt.Errorf(
+				"matrix has %d values but no row pointers",
+				len(m.Indices),
+			)
+		}
+		return nil
+	}
+
+	if m.RowPtr[0] != 0 {
+		return fmt.Errorf("first row pointer is %d; expected 0", m.RowPtr[0])
+	}
+	for i := 1; i < len(m.RowPtr); i++ {
+		if m.RowPtr[i] < m.RowPtr[i-1] {
+			return fmt.Errorf(
+				"row pointer %d (%d) is less than the one before it (%d)",
+				i,
+				m.RowPtr[i],
+				m.RowPtr[i-1],
+			)
+		}
+	}
+	if last := m.RowPtr[len(m.RowPtr)-1]; last != len(m.Values) {
+		return fmt.Errorf(
+			"last row pointer is %d but the matrix has %d values",
+			last,
+			len(m.Values),
+		)
+	}
+
+	return nil
+}
+
+// PredictContributionsSparse is like PredictContributions but takes only the
+// row's present features. Looking up the features costs time in proportion
+// to the number of present features, and each tree costs time in proportion
+// to the features it splits on, rather than the number of features the model
+// has, so this suits wide models whose rows are mostly missing. The result
+// still has one element per feature followed by the bias, so returning it
+// costs time in proportion to the number of features once per row.
+//
+// A feature is also missing if its value is NaN or the value set with the
+// Missing option.
+func (p *Predictor) PredictContributionsSparse(
+	row SparseVector,
+	opts ...PredictOption,
+) ([]float32, error) {
+	if err := p.checkSingleGroup("PredictContributionsSparse"); err != nil {
+		return nil, err
+	}
+
+	o, err := p.applyPredictOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	contribs := make([]float32, p.numFeature+1)
+	err = p.predictSparseContributionsInto(contribs, row, &o, &Scratch{})
+	if err != nil {
+		return nil, err
+	}
+
+	return contribs, nil
+}
+
+// PredictContributionsCSR calculates the contributions of features for each
+// row of m, splitting the rows across worker goroutines as
+// PredictContributionsBatch does. Each element of the result is what
+// PredictContributionsSparse returns for the corresponding row.
+func (p *Predictor) PredictContributionsCSR(
+	ctx context.Context,
+	m *CSRMatrix,
+	opts ...BatchOption,
+) ([][]float32, error) {
+	if err := p.checkSingleGroup("PredictContributionsCSR"); err != nil {
+		return nil, err
+	}
+
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	return p.runBatch(
+		ctx,
+		m.NumRows(),
+		opts,
+		func(i int, po *PredictOptions, scratch *Scratch) ([]float32, error) {
+			contribs := make([]float32, p.numFeature+1)
+			err := p.predictSparseContributionsInto(
+				contribs,
+				m.Row(i),
+				po,
+				scratch,
+			)
+			return contribs, err
+		},
+	)
+}
+
+// predictSparseContributionsInto fills a dense view of row, calculates its
+// contributions into contribs, and drops the row from the view again.
+//
+// This is like FVec's Fill() and Drop() in xgboost (tree_model.h), which
+// convert each sparse row to a dense vector in time proportional to its
+// number of entries.
+func (p *Predictor) predictSparseContributionsInto(
+	contribs []float32,
+	row SparseVector,
+	o *PredictOptions,
+	s *Scratch,
+) error {
+	if err := p.checkSparseRow(row); err != nil {
+		return err
+	}
+
+	features := s.fillSparse(p, row)
+	defer s.dropSparse(row)
+
+	return p.predictContributionsInto(contribs, features, o, false, 0, 0, s)
+}
+
+// checkSparseRow checks that row's indices are strictly increasing and refer
+// to features of the model.
+func (p *Predictor) checkSparseRow(row SparseVector) error {
+	if len(row.Indices) != len(row.Values) {
+		return fmt.Errorf(
+			"row has %d indices but %d values",
+			len(row.Indices),
+			len(row.Values),
+		)
+	}
+
+	prev := -1
+	for _, i := range row.Indices {
+		if i < 0 || i >= p.numFeature {
+			return fmt.Errorf(
+				"feature index %d is out of range for %d features",
+				i,
+				p.numFeature,
+			)
+		}
+		if i <= prev {
+			return fmt.Errorf(
+				"feature index %d follows %d; indices must be strictly increasing",
+				i,
+				prev,
+			)
+		}
+		prev = i
+	}
+
+	return nil
+}
+
+// fillSparse returns a dense view of row with p.numFeature features, in which
+// the features that row does not list are nil. The view is only valid until
+// dropSparse is called.
+func (s *Scratch) fillSparse(p *Predictor, row SparseVector) []*float32 {
+	// Every element of s.sparse is nil between calls, so only the row's
+	// entries need to be set here and cleared in dropSparse.
+	if len(s.sparse) < p.numFeature {
+		s.sparse = make([]*float32, p.numFeature)
+	}
+	features := s.sparse[:p.numFeature]
+
+	for k, i := range row.Indices {
+		if p.isMissingSentinel(row.Values[k]) {
+			continue
+		}
+		features[i] = &row.Values[k]
+	}
+
+	return features
+}
+
+// dropSparse clears the entries of row set by fillSparse.
+func (s *Scratch) dropSparse(row SparseVector) {
+	for _, i := range row.Indices {
+		s.sparse[i] = nil
+	}
+}
@@ -0,0 +1,279 @@
+package xgbshap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPredictContributionsSparse(t *testing.T) {
+	p, err := NewPredictor("testdata/roundtrip/model.json")
+	require.NoError(t, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
+	for row, features := range allFeatures {
+		want, err := p.PredictContributions(features)
+		require.NoError(t, err)
+
+		got, err := p.PredictContributionsSparse(toSparse(features))
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "row %d", row)
+	}
+
+	t.Run("Missing sentinel", func(t *testing.T) {
+		p, err := NewPredictor("testdata/roundtrip/model.json", Missing(-999))
+		require.NoError(t, err)
+
+		want, err := p.PredictContributions(
+			[]*float32{nil, toPtr(1), nil, nil, nil, nil},
+		)
+		require.NoError(t, err)
+
+		got, err := p.PredictContributionsSparse(SparseVector{
+			Indices: []int{0, 1},
+			Values:  []float32{-999, 1},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("empty row is all missing", func(t *testing.T) {
+		want, err := p.PredictContributions(make([]*float32, 6))
+		require.NoError(t, err)
+
+		got, err := p.PredictContributionsSparse(SparseVector{})
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	tests := []struct {
+		name    string
+		row     SparseVector
+		wantErr string
+	}{
+		{
+			name:    "mismatched lengths",
+			row:     SparseVector{Indices: []int{0, 1}, Values: []float32{1}},
+			wantErr: "row has 2 indices but 1 values",
+		},
+		{
+			name:    "unsorted",
+			row:     SparseVector{Indices: []int{2, 1}, Values: []float32{1, 2}},
+			wantErr: "feature index 1 follows 2; indices must be strictly increasing",
+		},
+		{
+			name:    "duplicate",
+			row:     SparseVector{Indices: []int{1, 1}, Values: []float32{1, 2}},
+			wantErr: "feature index 1 follows 1; indices must be strictly increasing",
+		},
+		{
+			name:    "negative",
+			row:     SparseVector{Indices: []int{-1}, Values: []float32{1}},
+			wantErr: "feature index -1 is out of range for 6 features",
+		},
+		{
+			name:    "out of range",
+			row:     SparseVector{Indices: []int{6}, Values: []float32{1}},
+			wantErr: "feature index 6 is out of range for 6 features",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := p.PredictContributionsSparse(test.row)
+			require.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestScratchSparseIsClearedAfterUse(t *testing.T) {
+	p, err := NewPredictor("testdata/roundtrip/model.json")
+	require.NoError(t, err)
+
+	var s Scratch
+	row := SparseVector{Indices: []int{0, 3, 5}, Values: []float32{1, 2, 3}}
+	contribs := make([]float32, 7)
+
+	require.NoError(t, p.predictSparseContributionsInto(
+		contribs,
+		row,
+		&PredictOptions{},
+		&s,
+	))
+	for i, f := range s.sparse {
+		assert.Nil(t, f, "feature %d", i)
+	}
+
+	// The entries are also cleared when the calculation fails.
+	p.invalidCategory = InvalidCategoryError
+	row.Values[1] = -1
+	var categoryErr *CategoryError
+	require.ErrorAs(t, p.predictSparseContributionsInto(
+		contribs,
+		row,
+		&PredictOptions{},
+		&s,
+	), &categoryErr)
+	for i, f := range s.sparse {
+		assert.Nil(t, f, "feature %d", i)
+	}
+}
+
+func TestPredictContributionsCSR(t *testing.T) {
+	p, err := NewPredictor("testdata/roundtrip/model.json")
+	require.NoError(t, err)
+
+	allFeatures, err := readFeaturesCSV("testdata/roundtrip/features.csv")
+	require.NoError(t, err)
+
+	want, err := p.PredictContributionsBatch(t.Context(), allFeatures)
+	require.NoError(t, err)
+
+	m := &CSRMatrix{RowPtr: []int{0}}
+	for _, features := range allFeatures {
+		row := toSparse(features)
+		m.Indices = append(m.Indices, row.Indices...)
+		m.Values = append(m.Values, row.Values...)
+		m.RowPtr = append(m.RowPtr, len(m.Values))
+	}
+	require.Equal(t, len(allFeatures), m.NumRows())
+
+	for _, workers := range []int{1, 4} {
+		got, err := p.PredictContributionsCSR(t.Context(), m, Workers(workers))
+		require.NoError(t, err, "workers=%d", workers)
+		assert.Equal(t, want, got, "workers=%d", workers)
+	}
+
+	t.Run("empty matrix", func(t *testing.T) {
+		got, err := p.PredictContributionsCSR(t.Context(), &CSRMatrix{})
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("row error includes the row", func(t *testing.T) {
+		bad := &CSRMatrix{
+			RowPtr:  []int{0, 1, 2},
+			Indices: []int{0, 9},
+			Values:  []float32{1, 2},
+		}
+		_, err := p.PredictContributionsCSR(t.Context(), bad)
+		require.EqualError(
+			t,
+			err,
+			"row 1: feature index 9 is out of range for 6 features",
+		)
+	})
+}
+
+func TestCSRMatrixValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       CSRMatrix
+		wantErr string
+	}{
+		{
+			name: "valid",
+			m: CSRMatrix{
+				RowPtr:  []int{0, 2, 2, 3},
+				Indices: []int{0, 1, 4},
+				Values:  []float32{1, 2, 3},
+			},
+		},
+		{
+			name: "empty",
+			m:    CSRMatrix{},
+		},
+		{
+			name: "mismatched lengths",
+			m: CSRMatrix{
+				RowPtr:  []int{0, 1},
+				Indices: []int{0},
+				Values:  []float32{1, 2},
+			},
+			wantErr: "matrix has 1 indices but 2 values",
+		},
+		{
+			name: "values without row pointers",
+			m: CSRMatrix{
+				Indices: []int{0},
+				Values:  []float32{1},
+			},
+			wantErr: "matrix has 1 values but no row pointers",
+		},
+		{
+			name: "nonzero first row pointer",
+			m: CSRMatrix{
+				RowPtr:  []int{1, 1},
+				Indices: []int{0},
+				Values:  []float32{1},
+			},
+			wantErr: "first row pointer is 1; expected 0",
+		},
+		{
+			name: "decreasing row pointers",
+			m: CSRMatrix{
+				RowPtr:  []int{0, 2, 1, 2},
+				Indices: []int{0, 1},
+				Values:  []float32{1, 2},
+			},
+			wantErr: "row pointer 2 (1) is less than the one before it (2)",
+		},
+		{
+			name: "last row pointer does not match",
+			m: CSRMatrix{
+				RowPtr:  []int{0, 1},
+				Indices: []int{0, 1},
+				Values:  []float32{1, 2},
+			},
+			wantErr: "last row pointer is 1 but the matrix has 2 values",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.m.validate()
+			if test.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func BenchmarkPredictContributionsSparse(b *testing.B) {
+	p, err := NewPredictor("testdata/small-model/model.json")
+	require.NoError(b, err)
+
+	row := SparseVector{
+		Indices: []int{0, 7, 12, 29},
+		Values:  []float32{1, 0.5, 3, 2},
+	}
+	contribs := make([]float32, 31)
+	var scratch Scratch
+
+	b.ReportAllocs()
+	for b.Loop() {
+		err := p.predictSparseContributionsInto(
+			contribs,
+			row,
+			&PredictOptions{},
+			&scratch,
+		)
+		require.NoError(b, err)
+	}
+}
+
+// toSparse converts features to a SparseVector listing the non-nil features.
+func toSparse(features []*float32) SparseVector {
+	var row SparseVector
+	for i, f := range features {
+		if f != nil {
+			row.Indices = append(row.Indices, i)
+			row.Values = append(row.Values, *f)
+		}
+	}
+	return row
+}
@@ -0,0 +1,73 @@
+#!/usr/bin/env python
+"""Generate random forest models with XGBoost's scikit-learn interface.
+
+Usage: python testdata/generate-rf-models.py
+
+This trains an XGBRFRegressor and a three-class XGBRFClassifier on numeric
+features with ~10% missing values. It writes each model, its test features,
+and XGBoost's golden SHAP contributions to testdata/xgbrf-regressor and
+testdata/xgbrf-classifier, so the Go tests can compare xgbshap's output for
+random forests, which have num_parallel_tree trees per round, against
+XGBoost's.
+
+For the classifier, each row of contributions.csv holds the contributions of
+each class in turn, i.e., XGBoost's (classes, features + 1) output flattened
+in row-major order.
+"""
+
+import os
+
+import numpy as np
+import pandas as pd  # type: ignore
+import xgboost as xgb
+
+RANDOM_SEED = 0
+N = 500
+NUM_FEATURES = 4
+NUM_TEST = 50
+
+PARAMS = {
+    "n_estimators": 8,
+    "max_depth": 4,
+    "subsample": 0.8,
+    "colsample_bynode": 0.8,
+    "n_jobs": 1,
+    "random_state": RANDOM_SEED,
+    "tree_method": "hist",
+}
+
+rng = np.random.default_rng(RANDOM_SEED)
+X = rng.normal(size=(N, NUM_FEATURES))
+score = X[:, 0] + X[:, 1] * X[:, 2] + rng.normal(scale=0.2, size=N)
+
+# Make some values NaN at random so the default directions are exercised.
+X[rng.random(X.shape) < 0.10] = np.nan
+
+X_train, X_test = X[NUM_TEST:], X[:NUM_TEST]
+
+models = {
+    "xgbrf-regressor": (xgb.XGBRFRegressor(**PARAMS), score),
+    "xgbrf-classifier": (
+        xgb.XGBRFClassifier(**PARAMS),
+        np.digitize(score, np.quantile(score, [1 / 3, 2 / 3])),
+    ),
+}
+
+testdata = os.path.dirname(os.path.abspath(__file__))
+for name, (model, y) in models.items():
+    model.fit(X_train, y[NUM_TEST:])
+    booster = model.get_booster()
+
+    contribs = booster.predict(
+        xgb.DMatrix(X_test, missing=np.nan), pred_contribs=True
+    )
+
+    out = os.path.join(testdata, name)
+    os.makedirs(out, exist_ok=True)
+    booster.save_model(os.path.join(out, "model.json"))
+    pd.DataFrame(X_test).to_csv(
+        os.path.join(out, "features.csv"), header=False, index=False
+    )
+    pd.DataFrame(contribs.reshape(len(contribs), -1)).to_csv(
+        os.path.join(out, "contributions.csv"), header=False, index=False
+    )
@@ -0,0 +1,66 @@
+#!/usr/bin/env python
+"""Generate golden interventional SHAP values with shap's TreeExplainer.
+
+This trains a small reg:squarederror model on numeric features with ~10%
+missing values and explains some rows against a background dataset with
+shap.TreeExplainer(feature_perturbation="interventional"), so the Go tests can
+compare InterventionalExplainer's output against shap's.
+
+It saves the model, the background rows, the explained rows, and the golden
+values. Each row of contributions.csv holds the features' SHAP values followed
+by shap's expected value, which is the bias InterventionalExplainer returns.
+"""
+
+import numpy as np
+import pandas as pd  # type: ignore
+import shap  # type: ignore
+import xgboost as xgb
+
+RANDOM_SEED = 0
+rng = np.random.default_rng(RANDOM_SEED)
+
+N = 400
+NUM_FEATURES = 5
+NUM_BACKGROUND = 100
+NUM_EXPLAINED = 20
+
+X = rng.normal(size=(N, NUM_FEATURES))
+y = X[:, 0] * X[:, 1] + np.sin(X[:, 2]) + (X[:, 3] > 0) + rng.normal(
+    scale=0.1, size=N
+)
+
+# Make some values NaN at random so the default directions are exercised.
+X[rng.random(X.shape) < 0.10] = np.nan
+
+dtrain = xgb.DMatrix(X, label=y, missing=np.nan)
+booster = xgb.train(
+    {
+        "objective": "reg:squarederror",
+        "eta": 0.3,
+        "max_depth": 4,
+        "nthread": 1,
+        "seed": RANDOM_SEED,
+        "tree_method": "hist",
+    },
+    dtrain,
+    20,
+)
+booster.save_model("model.json")
+
+background = X[:NUM_BACKGROUND]
+explained = X[NUM_BACKGROUND : NUM_BACKGROUND + NUM_EXPLAINED]
+
+explainer = shap.TreeExplainer(
+    booster,
+    data=background,
+    feature_perturbation="interventional",
+    model_output="raw",
+)
+values = explainer.shap_values(explained, check_additivity=True)
+bias = np.full((len(explained), 1), explainer.expected_value)
+
+pd.DataFrame(background).to_csv("background.csv", header=False, index=False)
+pd.DataFrame(explained).to_csv("features.csv", header=False, index=False)
+pd.DataFrame(np.hstack([values, bias])).to_csv(
+    "contributions.csv", header=False, index=False
+)
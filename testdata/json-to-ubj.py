@@ -0,0 +1,102 @@
+#!/usr/bin/env python
+"""Convert an XGBoost JSON model to XGBoost's UBJSON (.ubj) model format.
+
+Usage: json-to-ubj.py model.json model.ubj
+
+This produces the same layout as XGBoost's own UBJSON writer (UBJWriter in
+src/common/json.cc): objects and arrays are big-endian, object keys and strings
+use an int64 length, plain arrays carry an int64 count, integers are written as
+int64, floats as float32, and the per-tree arrays are written as strongly typed
+arrays. It exists so the UBJSON fixtures can be regenerated from the JSON
+fixtures without installing XGBoost, e.g.:
+
+    python testdata/json-to-ubj.py testdata/roundtrip/model.json \\
+        testdata/roundtrip/model.ubj
+"""
+
+import json
+import struct
+import sys
+
+# The typed array element marker XGBoost uses for each tree array.
+TYPED_ARRAYS = {
+    "base_weights": "d",
+    "loss_changes": "d",
+    "split_conditions": "d",
+    "sum_hessian": "d",
+    "weight_drop": "d",
+    "categories": "l",
+    "categories_nodes": "l",
+    "left_children": "l",
+    "parents": "l",
+    "right_children": "l",
+    "split_indices": "l",
+    "tree_info": "l",
+    "default_left": "U",
+    "split_type": "U",
+    "categories_segments": "L",
+    "categories_sizes": "L",
+}
+
+PACK_FORMATS = {"d": ">f", "l": ">i", "U": ">B", "L": ">q"}
+
+
+def write_length(out, n):
+    out += b"L" + struct.pack(">q", n)
+
+
+def write_str(out, s):
+    b = s.encode("utf-8")
+    write_length(out, len(b))
+    out += b
+
+
+def write_value(out, value, key=None):
+    if key in TYPED_ARRAYS and isinstance(value, list):
+        marker = TYPED_ARRAYS[key]
+        out += b"[$" + marker.encode() + b"#"
+        write_length(out, len(value))
+        fmt = PACK_FORMATS[marker]
+        for v in value:
+            out += struct.pack(fmt, float(v) if marker == "d" else int(v))
+    elif value is None:
+        out += b"Z"
+    elif value is True:
+        out += b"T"
+    elif value is False:
+        out += b"F"
+    elif isinstance(value, int):
+        out += b"L" + struct.pack(">q", value)
+    elif isinstance(value, float):
+        out += b"d" + struct.pack(">f", value)
+    elif isinstance(value, str):
+        out += b"S"
+        write_str(out, value)
+    elif isinstance(value, list):
+        out += b"[#"
+        write_length(out, len(value))
+        for v in value:
+            write_value(out, v)
+    elif isinstance(value, dict):
+        out += b"{"
+        for k, v in value.items():
+            write_str(out, k)
+            write_value(out, v, k)
+        out += b"}"
+    else:
+        raise TypeError(f"unsupported value {value!r}")
+
+
+def main():
+    with open(sys.argv[1], encoding="utf-8") as f:
+        model = json.load(f)
+
+    out = bytearray()
+    write_value(out, model)
+
+    with open(sys.argv[2], "wb") as f:
+        f.write(out)
+
+
+if __name__ == "__main__":
+    main()
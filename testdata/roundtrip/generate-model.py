@@ -3,8 +3,12 @@
 
 This trains a binary:logistic model with tree_method=hist, mixed
 numeric/categorical features, and ~15% missing values. It saves the model, test
-features, and golden SHAP contributions so the Go test can compare xgbshap's
-output against XGBoost's.
+features, and golden SHAP contributions and interactions so the Go tests can
+compare xgbshap's output against XGBoost's.
+
+interactions.csv has one row per test row, holding XGBoost's
+(features + 1) x (features + 1) interaction matrix flattened in row-major
+order.
 """
 
 import random
@@ -93,6 +97,9 @@ booster = xgb.train(
 )
 
 booster.save_model("model.json")
+# The same model as written by XGBoost's own UBJSON writer (XGBoost 2.0 or
+# later), unlike model.ubj, which json-to-ubj.py converts from model.json.
+booster.save_model("xgboost-model.ubj")
 
 # Use iteration_range so contributions use the same tree subset that xgbshap
 # will use via best_ntree_limit.
@@ -104,3 +111,10 @@ contribs = booster.predict(
 
 pd.DataFrame(X_test).to_csv("features.csv", header=False, index=False)
 pd.DataFrame(contribs).to_csv("contributions.csv", header=False, index=False)
+
+interactions = booster.predict(
+    dtest, pred_interactions=True, iteration_range=iteration_range
+)
+pd.DataFrame(interactions.reshape(len(interactions), -1)).to_csv(
+    "interactions.csv", header=False, index=False
+)
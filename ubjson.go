@@ -0,0 +1,376 @@
+package xgbshap
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// XGBoost 2.0 and later save models as Universal Binary JSON
+// (https://ubjson.org) by default. Rather than maintaining a second mapping
+// onto XGBModel, we transcode UBJSON into JSON and decode that with the same
+// code used for JSON models. Floats are stored natively in UBJSON, so
+// non-finite values are written as the quoted tokens that xgbFloat accepts
+// instead of going through sanitizeNonFiniteNumbers.
+
+// maxUBJSONDepth bounds container nesting so malformed input cannot exhaust
+// the stack. It matches the nesting limit of encoding/json.
+const maxUBJSONDepth = 10000
+
+// isUBJSON reports whether buf holds a UBJSON document rather than a JSON one.
+// Both formats start an XGBoost model with '{', but a JSON object continues
+// with whitespace, a quote, or '}', while a UBJSON object continues with a
+// container optimization marker or the integer marker of its first key's
+// length.
+func isUBJSON(buf []byte) bool {
+	if len(buf) < 2 || buf[0] != '{' {
+		return false
+	}
+	switch buf[1] {
+	case 'i', 'U', 'I', 'l', 'L', '$', '#':
+		return true
+	default:
+		return false
+	}
+}
+
+// ubjsonToJSON transcodes a UBJSON document into the equivalent JSON.
+func ubjsonToJSON(data []byte) ([]byte, error) {
+	d := ubjsonDecoder{
+		data: data,
+		out:  make([]byte, 0, 2*len(data)),
+	}
+
+	marker, err := d.marker()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.value(marker, 0); err != nil {
+		return nil, err
+	}
+
+	for d.pos < len(d.data) {
+		if d.data[d.pos] != 'N' {
+			return nil, fmt.Errorf("unexpected data after value at offset %d", d.pos)
+		}
+		d.pos++
+	}
+
+	return d.out, nil
+}
+
+type ubjsonDecoder struct {
+	data []byte
+	pos  int
+	out  []byte
+}
+
+// marker reads the next type marker, skipping no-op markers.
+func (d *ubjsonDecoder) marker() (byte, error) {
+	for d.pos < len(d.data) {
+		m := d.data[d.pos]
+		d.pos++
+		if m != 'N' {
+			return m, nil
+		}
+	}
+	return 0, errors.New("unexpected end of UBJSON data")
+}
+
+func (d *ubjsonDecoder) read(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, fmt.Errorf(
+			"need %d bytes at offset %d but only %d remain",
+			n,
+			d.pos,
+			len(d.data)-d.pos,
+		)
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// value transcodes a value whose type marker has already been read.
+func (d *ubjsonDecoder) value(marker byte, depth int) error {
+	switch marker {
+	case 'Z':
+		d.out = append(d.out, "null"...)
+	case 'T':
+		d.out = append(d.out, "true"...)
+	case 'F':
+		d.out = append(d.out, "false"...)
+	case 'i', 'U', 'I', 'l', 'L':
+		n, err := d.integer(marker)
+		if err != nil {
+			return err
+		}
+		d.out = strconv.AppendInt(d.out, n, 10)
+	case 'd':
+		b, err := d.read(4)
+		if err != nil {
+			return err
+		}
+		f := math.Float32frombits(binary.BigEndian.Uint32(b))
+		d.out = appendJSONFloat(d.out, float64(f), 32)
+	case 'D':
+		b, err := d.read(8)
+		if err != nil {
+			return err
+		}
+		f := math.Float64frombits(binary.BigEndian.Uint64(b))
+		d.out = appendJSONFloat(d.out, f, 64)
+	case 'H':
+		s, err := d.string()
+		if err != nil {
+			return err
+		}
+		// The payload is a decimal string that must already be a valid JSON
+		// number for us to pass it through.
+		if len(s) == 0 || (s[0] != '-' && (s[0] < '0' || s[0] > '9')) ||
+			!json.Valid(s) {
+			return fmt.Errorf("invalid high-precision number %q", s)
+		}
+		d.out = append(d.out, s...)
+	case 'C':
+		b, err := d.read(1)
+		if err != nil {
+			return err
+		}
+		return d.appendString(b)
+	case 'S':
+		s, err := d.string()
+		if err != nil {
+			return err
+		}
+		return d.appendString(s)
+	case '[':
+		return d.container('[', depth+1)
+	case '{':
+		return d.container('{', depth+1)
+	default:
+		return fmt.Errorf(
+			"unexpected UBJSON marker %q at offset %d",
+			marker,
+			d.pos-1,
+		)
+	}
+	return nil
+}
+
+// container transcodes an array (open '[') or an object (open '{'), handling
+// the optimized forms that declare a count and optionally an element type.
+func (d *ubjsonDecoder) container(open byte, depth int) error {
+	if depth > maxUBJSONDepth {
+		return fmt.Errorf("UBJSON nesting exceeds %d levels", maxUBJSONDepth)
+	}
+
+	isObject := open == '{'
+	closing := byte(']')
+	if isObject {
+		closing = '}'
+	}
+
+	var elemType byte
+	count := -1
+	if d.pos < len(d.data) && d.data[d.pos] == '$' {
+		d.pos++
+		t, err := d.read(1)
+		if err != nil {
+			return err
+		}
+		elemType = t[0]
+		if d.pos >= len(d.data) || d.data[d.pos] != '#' {
+			return fmt.Errorf(
+				"typed container at offset %d has no count",
+				d.pos,
+			)
+		}
+	}
+	if d.pos < len(d.data) && d.data[d.pos] == '#' {
+		d.pos++
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		// Every element takes at least one byte (a marker, or the value
+		// itself in a typed container), so a count larger than the remaining
+		// input is malformed. Checking it here keeps a corrupt count from
+		// driving an unbounded amount of output.
+		size := 1
+		if elemType != 0 {
+			size = typedElementSize(elemType)
+			if size == 0 {
+				return fmt.Errorf(
+					"unsupported typed container element %q",
+					elemType,
+				)
+			}
+		}
+		if n > (len(d.data)-d.pos)/size {
+			return fmt.Errorf("container count %d exceeds remaining data", n)
+		}
+		count = n
+	}
+
+	d.out = append(d.out, open)
+	for i := 0; count < 0 || i < count; i++ {
+		if count < 0 && d.atContainerEnd(closing) {
+			break
+		}
+		if i > 0 {
+			d.out = append(d.out, ',')
+		}
+
+		if isObject {
+			key, err := d.string()
+			if err != nil {
+				return fmt.Errorf("reading object key: %w", err)
+			}
+			if err := d.appendString(key); err != nil {
+				return err
+			}
+			d.out = append(d.out, ':')
+		}
+
+		marker := elemType
+		if marker == 0 {
+			var err error
+			marker, err = d.marker()
+			if err != nil {
+				return err
+			}
+		}
+		if err := d.value(marker, depth); err != nil {
+			return err
+		}
+	}
+	d.out = append(d.out, closing)
+
+	return nil
+}
+
+// atContainerEnd skips no-op markers and reports whether the next byte ends
+// a container without a declared count, consuming it if so.
+func (d *ubjsonDecoder) atContainerEnd(closing byte) bool {
+	for d.pos < len(d.data) && d.data[d.pos] == 'N' {
+		d.pos++
+	}
+	if d.pos < len(d.data) && d.data[d.pos] == closing {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+// typedElementSize returns the encoded size of an element of a strongly typed
+// container, or 0 for element types we do not accept there. Types with no
+// payload (null, true, false) are rejected since XGBoost never writes them
+// and they would allow a tiny input to expand without bound.
+func typedElementSize(marker byte) int {
+	switch marker {
+	case 'i', 'U', 'C':
+		return 1
+	case 'I':
+		return 2
+	case 'l', 'd':
+		return 4
+	case 'L', 'D':
+		return 8
+	case 'S', 'H', '[', '{':
+		// Variable length, but at least one byte.
+		return 1
+	default:
+		return 0
+	}
+}
+
+// integer reads an integer of the given type.
+func (d *ubjsonDecoder) integer(marker byte) (int64, error) {
+	var size int
+	switch marker {
+	case 'i', 'U':
+		size = 1
+	case 'I':
+		size = 2
+	case 'l':
+		size = 4
+	case 'L':
+		size = 8
+	default:
+		return 0, fmt.Errorf("expected integer marker, got %q", marker)
+	}
+
+	b, err := d.read(size)
+	if err != nil {
+		return 0, err
+	}
+
+	switch marker {
+	case 'i':
+		return int64(int8(b[0])), nil
+	case 'U':
+		return int64(b[0]), nil
+	case 'I':
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case 'l':
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	default:
+		return int64(binary.BigEndian.Uint64(b)), nil //nolint:gosec // Reinterpreting the bits is the encoding.
+	}
+}
+
+// length reads a non-negative length or count, which UBJSON encodes as an
+// integer value including its marker.
+func (d *ubjsonDecoder) length() (int, error) {
+	marker, err := d.marker()
+	if err != nil {
+		return 0, err
+	}
+	n, err := d.integer(marker)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > math.MaxInt32 {
+		return 0, fmt.Errorf("invalid UBJSON length %d", n)
+	}
+	return int(n), nil
+}
+
+// string reads a length-prefixed string payload, as used for string values
+// and object keys.
+func (d *ubjsonDecoder) string() ([]byte, error) {
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	return d.read(n)
+}
+
+func (d *ubjsonDecoder) appendString(s []byte) error {
+	b, err := json.Marshal(string(s))
+	if err != nil {
+		return fmt.Errorf("encoding string: %w", err)
+	}
+	d.out = append(d.out, b...)
+	return nil
+}
+
+// appendJSONFloat appends f using the shortest representation that round
+// trips at the given bit size. Non-finite values are appended as the quoted
+// tokens that xgbFloat accepts.
+func appendJSONFloat(out []byte, f float64, bitSize int) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(out, `"NaN"`...)
+	case math.IsInf(f, 1):
+		return append(out, `"Infinity"`...)
+	case math.IsInf(f, -1):
+		return append(out, `"-Infinity"`...)
+	default:
+		return strconv.AppendFloat(out, f, 'g', -1, bitSize)
+	}
+}
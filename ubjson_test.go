@@ -0,0 +1,177 @@
+package xgbshap
+
+import (
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsUBJSON(t *testing.T) {
+	assert.True(t, isUBJSON(readFile(t, "testdata/roundtrip/model.ubj")))
+	assert.False(t, isUBJSON(readFile(t, "testdata/roundtrip/model.json")))
+	assert.False(t, isUBJSON([]byte(`{"learner":{}}`)))
+	assert.False(t, isUBJSON([]byte("{\n")))
+	assert.False(t, isUBJSON([]byte(`{}`)))
+	assert.False(t, isUBJSON(nil))
+}
+
+func TestUBJSONToJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "object with count-less array",
+			in:   "{i\x01a[i\x01U\x02]}",
+			want: `{"a":[1,2]}`,
+		},
+		{
+			name: "integer widths and signs",
+			in:   "[i\xffU\xffI\x01\x00l\xff\xff\xff\xfeL\x00\x00\x00\x00\x00\x00\x00\x07]",
+			want: `[-1,255,256,-2,7]`,
+		},
+		{
+			name: "scalars",
+			in:   "[ZTFC\x78Si\x03abcHi\x031.5]",
+			want: `[null,true,false,"x","abc",1.5]`,
+		},
+		{
+			name: "no-op markers are skipped",
+			in:   "N[NTNN]N",
+			want: `[true]`,
+		},
+		{
+			name: "counted array",
+			in:   "[#i\x02TF",
+			want: `[true,false]`,
+		},
+		{
+			name: "typed float32 array",
+			in:   "[$d#i\x02\x3f\xc0\x00\x00\xff\x80\x00\x00",
+			want: `[1.5,"-Infinity"]`,
+		},
+		{
+			name: "typed uint8 array",
+			in:   "[$U#L\x00\x00\x00\x00\x00\x00\x00\x03\x00\x01\x00",
+			want: `[0,1,0]`,
+		},
+		{
+			name: "typed empty array",
+			in:   "[$l#L\x00\x00\x00\x00\x00\x00\x00\x00",
+			want: `[]`,
+		},
+		{
+			name: "typed object",
+			in:   "{$i#i\x02i\x01a\x01i\x01b\x02",
+			want: `{"a":1,"b":2}`,
+		},
+		{
+			name: "float64 non-finite values",
+			in: "[D\x7f\xf0\x00\x00\x00\x00\x00\x00" +
+				"D\x7f\xf8\x00\x00\x00\x00\x00\x00]",
+			want: `["Infinity","NaN"]`,
+		},
+		{
+			name: "strings are escaped",
+			in:   "[Si\x03a\"b]",
+			want: `["a\"b"]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ubjsonToJSON([]byte(test.in))
+			require.NoError(t, err)
+			assert.JSONEq(t, test.want, string(got))
+		})
+	}
+}
+
+func TestUBJSONToJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{name: "empty", in: ""},
+		{name: "unknown marker", in: "X"},
+		{name: "truncated integer", in: "l\x00\x00"},
+		{name: "truncated string", in: "Si\x05ab"},
+		{name: "unterminated array", in: "[TT"},
+		{name: "negative length", in: "Si\xff"},
+		{name: "non-integer length", in: "Sd\x00\x00\x00\x00"},
+		{name: "typed container without count", in: "[$dT]"},
+		{name: "typed container with payload-less type", in: "[$Z#i\x05"},
+		{
+			name: "count exceeds remaining data",
+			in:   "[$d#L\x00\x00\x00\x00\x7f\xff\xff\xff\x00\x00\x00\x00",
+		},
+		{name: "invalid high-precision number", in: "Hi\x03abc"},
+		{name: "trailing data", in: "TT"},
+		{name: "non-string object key", in: "{Si\x01a}"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ubjsonToJSON([]byte(test.in))
+			require.Error(t, err)
+		})
+	}
+
+	t.Run("excessive nesting", func(t *testing.T) {
+		in := make([]byte, maxUBJSONDepth+1)
+		for i := range in {
+			in[i] = '['
+		}
+		_, err := ubjsonToJSON(in)
+		require.ErrorContains(t, err, "nesting")
+	})
+}
+
+func TestParseModelUBJSON(t *testing.T) {
+	for _, dir := range []string{"roundtrip", "neg-inf-split", "dart"} {
+		t.Run(dir, func(t *testing.T) {
+			jsonModel, jsonTrees, err := parseModel(
+				readFile(t, "testdata/"+dir+"/model.json"),
+			)
+			require.NoError(t, err)
+
+			ubjModel, ubjTrees, err := parseModel(
+				readFile(t, "testdata/"+dir+"/model.ubj"),
+			)
+			require.NoError(t, err)
+
+			assert.Equal(t, jsonModel, ubjModel)
+			assert.Equal(t, jsonTrees, ubjTrees)
+		})
+	}
+
+	t.Run("native -Infinity split condition", func(t *testing.T) {
+		_, trees, err := parseModel(
+			readFile(t, "testdata/neg-inf-split/model.ubj"),
+		)
+		require.NoError(t, err)
+		assert.True(t, math.IsInf(float64(trees[0].Nodes[0].Data.SplitCondition), -1))
+	})
+}
+
+func FuzzUBJSONToJSON(f *testing.F) {
+	models, err := filepath.Glob("testdata/*/model.ubj")
+	require.NoError(f, err)
+	for _, path := range models {
+		f.Add(readFile(f, path))
+	}
+	f.Add([]byte("{i\x01a[$d#i\x02\x7f\x80\x00\x00\xff\x80\x00\x00}"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out, err := ubjsonToJSON(data)
+		if err != nil {
+			return
+		}
+		assert.True(t, json.Valid(out), "invalid JSON: %s", out)
+	})
+}